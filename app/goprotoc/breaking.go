@@ -0,0 +1,592 @@
+package goprotoc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/ast"
+	"github.com/bufbuild/protocompile/reporter"
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+//lint:file-ignore ST1005 capitalized errors that are sentences are command return values printed to stderr
+
+// breakingCategory is a set of kinds of compatibility that a change can
+// break.
+type breakingCategory int
+
+const (
+	// breakingWire changes break decoding of the binary format.
+	breakingWire breakingCategory = 1 << iota
+	// breakingWireJSON changes break decoding of either the binary or the
+	// JSON format.
+	breakingWireJSON
+	// breakingSource changes break code that uses the generated code.
+	breakingSource
+
+	breakingAll = breakingWire | breakingWireJSON | breakingSource
+)
+
+func parseBreakingCategories(s string) (breakingCategory, error) {
+	var categories breakingCategory
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "WIRE":
+			categories |= breakingWire
+		case "WIRE_JSON":
+			categories |= breakingWireJSON
+		case "SOURCE":
+			categories |= breakingSource
+		default:
+			return 0, fmt.Errorf("Unknown breaking change category: %s", name)
+		}
+	}
+	return categories, nil
+}
+
+// breakingChange is an incompatible change found by comparing two versions of
+// a file.
+type breakingChange struct {
+	categories breakingCategory
+	pos        ast.SourcePos
+	message    string
+}
+
+// runBreaking implements the breaking command, which compares the given files
+// with an older version of them and reports any incompatible changes. The
+// older version, given by --against, is either a descriptor set file or a
+// directory that contains the older source files, in which case their imports
+// are also resolved relative to the directory before the import paths.
+func runBreaking(programName string, args []string, stdout io.Writer, stderr io.Writer) error {
+	againstValues, args, err := extractFlag(args, "--against")
+	if err != nil {
+		return err
+	}
+	categoryValues, args, err := extractFlag(args, "--category")
+	if err != nil {
+		return err
+	}
+	var opts protocOptions
+	if err := parseFlags("", programName, args, stdout, &opts, map[string]struct{}{}); err != nil {
+		switch err {
+		case errVersion, errUsage:
+			return nil
+		default:
+			return err
+		}
+	}
+	if len(againstValues) == 0 || againstValues[len(againstValues)-1] == "" {
+		return errors.New("The breaking command requires --against=OLD.")
+	}
+	categories := breakingAll
+	if len(categoryValues) > 0 {
+		categories = 0
+		for _, value := range categoryValues {
+			c, err := parseBreakingCategories(value)
+			if err != nil {
+				return err
+			}
+			categories |= c
+		}
+	}
+	return breakingWithOptions(&opts, againstValues[len(againstValues)-1], categories, stderr)
+}
+
+func breakingWithOptions(opts *protocOptions, against string, categories breakingCategory, stderr io.Writer) (e error) {
+	errPrinter := &errorPrinter{format: opts.errorFormat, importPaths: opts.includePaths}
+	defer func() {
+		if e != errFatalWarnings {
+			e = errPrinter.formatError(e)
+		}
+	}()
+
+	if len(opts.protoFiles) == 0 {
+		return errors.New("Missing input file.")
+	}
+	if usesOutputOptions(opts) || opts.codeGen.check || opts.codeGen.diff {
+		return errors.New("Only --proto_path, --exclude, --error_format, and --fatal_warnings can be used with breaking.")
+	}
+
+	fileNames, err := resolveInputs(opts)
+	if err != nil {
+		return err
+	}
+	fds, err := parseSources(fileNames, opts, true, errPrinter, stderr, nil)
+	if err != nil {
+		return err
+	}
+	oldFiles, deleted, err := loadAgainst(against, fds, opts)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	addChanges := func(changes []breakingChange) {
+		for _, change := range changes {
+			if change.categories&categories != 0 {
+				errs = append(errs, reporter.Error(ast.NewSourceSpan(change.pos, change.pos), errors.New(change.message)))
+			}
+		}
+	}
+	for _, fd := range fds {
+		oldFd, ok := oldFiles[fd.GetName()]
+		if !ok {
+			// a new file
+			continue
+		}
+		addChanges(compareFiles(oldFd, fd))
+	}
+	for _, oldFd := range deleted {
+		addChanges(deletedFile(oldFd))
+	}
+	return toError(errs)
+}
+
+// loadAgainst returns the files from the older version with the same names as
+// the given files, keyed by name. Files that don't exist in the older version
+// are omitted. It also returns, sorted by name, the files from the older
+// version that were deleted: those that are neither one of the given files
+// nor one of their imports, and that can't be found in the import paths. All
+// files in a descriptor set are considered, and all .proto files in a
+// directory that are not excluded by --exclude.
+func loadAgainst(against string, fds []*desc.FileDescriptor, opts *protocOptions) (map[string]*desc.FileDescriptor, []*desc.FileDescriptor, error) {
+	info, err := os.Stat(against)
+	if err != nil {
+		return nil, nil, err
+	}
+	newFiles := map[string]struct{}{}
+	var addFile func(fd *desc.FileDescriptor)
+	addFile = func(fd *desc.FileDescriptor) {
+		if _, ok := newFiles[fd.GetName()]; ok {
+			return
+		}
+		newFiles[fd.GetName()] = struct{}{}
+		for _, dep := range fd.GetDependencies() {
+			addFile(dep)
+		}
+	}
+	for _, fd := range fds {
+		addFile(fd)
+	}
+	importPaths := opts.includePaths
+	if len(importPaths) == 0 {
+		importPaths = []string{"."}
+	}
+	inputs := map[string]struct{}{}
+	for _, fd := range fds {
+		inputs[fd.GetName()] = struct{}{}
+	}
+	// selects the old files to load and returns the names of those that
+	// were deleted
+	var names, deletedNames []string
+	selectFiles := func(candidates []string) {
+		for _, name := range candidates {
+			if _, ok := inputs[name]; ok {
+				names = append(names, name)
+			} else if isDeletedFile(name, newFiles, importPaths) {
+				names = append(names, name)
+				deletedNames = append(deletedNames, name)
+			}
+		}
+		sort.Strings(deletedNames)
+	}
+
+	oldFiles := map[string]*desc.FileDescriptor{}
+	if !info.IsDir() {
+		allFiles, err := readDescriptorSets([]string{against})
+		if err != nil {
+			return nil, nil, err
+		}
+		candidates := make([]string, 0, len(allFiles))
+		for name := range allFiles {
+			candidates = append(candidates, name)
+		}
+		selectFiles(candidates)
+		linked := map[string]*desc.FileDescriptor{}
+		for _, name := range names {
+			fd, err := linkFile(name, allFiles, linked, nil)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not load %q from %s: %v", name, against, err)
+			}
+			oldFiles[name] = fd
+		}
+	} else {
+		candidates, err := globFiles(against, "**/*.proto")
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range candidates {
+			if candidates[i], err = filepath.Rel(against, candidates[i]); err != nil {
+				return nil, nil, err
+			}
+			candidates[i] = filepath.ToSlash(candidates[i])
+		}
+		if candidates, err = filterInputs(candidates, opts.excludes); err != nil {
+			return nil, nil, err
+		}
+		selectFiles(candidates)
+		if len(names) > 0 {
+			oldOpts := *opts
+			oldOpts.includePaths = append([]string{against}, importPaths...)
+			oldOpts.fatalWarnings = false
+			oldErrPrinter := &errorPrinter{format: opts.errorFormat, importPaths: oldOpts.includePaths}
+			oldFds, err := parseSources(names, &oldOpts, true, oldErrPrinter, io.Discard, nil)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse files in %s:\n%v", against, oldErrPrinter.formatError(err))
+			}
+			for _, fd := range oldFds {
+				oldFiles[fd.GetName()] = fd
+			}
+		}
+	}
+
+	deleted := make([]*desc.FileDescriptor, len(deletedNames))
+	for i, name := range deletedNames {
+		deleted[i] = oldFiles[name]
+		delete(oldFiles, name)
+	}
+	return oldFiles, deleted, nil
+}
+
+// isDeletedFile returns true if the file with the given name, from the older
+// version, no longer exists: it is not one of the given new files, it can't be
+// found in the given import paths, and it is not a standard import.
+func isDeletedFile(name string, newFiles map[string]struct{}, importPaths []string) bool {
+	if _, ok := newFiles[name]; ok {
+		return false
+	}
+	if _, ok := findOnDisk(name, importPaths); ok {
+		return false
+	}
+	standardImports := protocompile.WithStandardImports(protocompile.ResolverFunc(func(string) (protocompile.SearchResult, error) {
+		return protocompile.SearchResult{}, os.ErrNotExist
+	}))
+	_, err := standardImports.FindFileByPath(name)
+	return err != nil
+}
+
+// deletedFile returns the changes for a file from the older version that was
+// deleted. They are reported at the file's name, since it has no position in
+// the new version.
+func deletedFile(fd *desc.FileDescriptor) []breakingChange {
+	pos := ast.UnknownPos(fd.GetName())
+	changes := []breakingChange{{
+		categories: breakingSource,
+		pos:        pos,
+		message:    fmt.Sprintf("file %q was deleted", fd.GetName()),
+	}}
+	for _, sd := range fd.GetServices() {
+		changes = append(changes, breakingChange{
+			categories: breakingAll,
+			pos:        pos,
+			message:    fmt.Sprintf("service %q was deleted", sd.GetName()),
+		})
+	}
+	return changes
+}
+
+// breakingChecker accumulates the changes found when comparing two versions
+// of a file. Elements are matched by name relative to their file's package,
+// so renaming the package is reported only once.
+type breakingChecker struct {
+	oldPkg, newPkg string
+	changes        []breakingChange
+}
+
+func compareFiles(oldFd, newFd *desc.FileDescriptor) []breakingChange {
+	c := &breakingChecker{oldPkg: oldFd.GetPackage(), newPkg: newFd.GetPackage()}
+	if oldFd.GetPackage() != newFd.GetPackage() {
+		// the package is renamed, which changes the full names of all
+		// elements, such as those used in RPC paths and Any messages
+		c.report(newFd, breakingAll, "package changed from %q to %q", oldFd.GetPackage(), newFd.GetPackage())
+	}
+	c.compareMessages(oldFd.GetMessageTypes(), newFd.GetMessageTypes(), newFd)
+	c.compareEnums(oldFd.GetEnumTypes(), newFd.GetEnumTypes(), newFd)
+	for _, oldSd := range oldFd.GetServices() {
+		newSd := newFd.FindService(qualify(c.newPkg, oldSd.GetName()))
+		if newSd == nil {
+			c.report(newFd, breakingAll, "service %q was deleted", oldSd.GetName())
+			continue
+		}
+		c.compareService(oldSd, newSd)
+	}
+	return c.changes
+}
+
+func (c *breakingChecker) report(d desc.Descriptor, categories breakingCategory, format string, args ...interface{}) {
+	c.changes = append(c.changes, breakingChange{
+		categories: categories,
+		pos:        sourcePos(d),
+		message:    fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *breakingChecker) compareMessages(oldMds, newMds []*desc.MessageDescriptor, parent desc.Descriptor) {
+	newByName := map[string]*desc.MessageDescriptor{}
+	for _, md := range newMds {
+		newByName[md.GetName()] = md
+	}
+	for _, oldMd := range oldMds {
+		if oldMd.IsMapEntry() {
+			// compared as part of the map field
+			continue
+		}
+		newMd := newByName[oldMd.GetName()]
+		if newMd == nil {
+			c.report(parent, breakingSource, "message %q was deleted", c.oldName(oldMd))
+			continue
+		}
+		c.compareMessage(oldMd, newMd)
+	}
+}
+
+func (c *breakingChecker) compareMessage(oldMd, newMd *desc.MessageDescriptor) {
+	msgName := c.oldName(oldMd)
+	for _, oldFld := range oldMd.GetFields() {
+		newFld := newMd.FindFieldByNumber(oldFld.GetNumber())
+		if newFld == nil {
+			if renumbered := newMd.FindFieldByName(oldFld.GetName()); renumbered != nil {
+				c.report(renumbered, breakingAll, "field %q on message %q changed number from %d to %d", oldFld.GetName(), msgName, oldFld.GetNumber(), renumbered.GetNumber())
+				continue
+			}
+			// Deleting a field is only compatible on the wire if its number
+			// can't be reused, and in JSON if its name also can't be reused.
+			categories := breakingSource
+			if !isReservedNumber(newMd.AsDescriptorProto(), oldFld.GetNumber()) {
+				categories |= breakingWire | breakingWireJSON
+			} else if !isReservedName(newMd.AsDescriptorProto().GetReservedName(), oldFld.GetName()) {
+				categories |= breakingWireJSON
+			}
+			c.report(newMd, categories, "field %q (%d) on message %q was deleted", oldFld.GetName(), oldFld.GetNumber(), msgName)
+			continue
+		}
+		c.compareField(msgName, oldFld, newFld)
+	}
+	c.compareMessages(oldMd.GetNestedMessageTypes(), newMd.GetNestedMessageTypes(), newMd)
+	c.compareEnums(oldMd.GetNestedEnumTypes(), newMd.GetNestedEnumTypes(), newMd)
+}
+
+func (c *breakingChecker) compareField(msgName string, oldFld, newFld *desc.FieldDescriptor) {
+	if oldFld.GetName() != newFld.GetName() {
+		c.report(newFld, breakingSource, "field %d on message %q changed name from %q to %q", oldFld.GetNumber(), msgName, oldFld.GetName(), newFld.GetName())
+	}
+	if oldFld.GetJSONName() != newFld.GetJSONName() {
+		c.report(newFld, breakingWireJSON, "field %q on message %q changed JSON name from %q to %q", oldFld.GetName(), msgName, oldFld.GetJSONName(), newFld.GetJSONName())
+	}
+	if oldType, newType := c.fieldType(oldFld, c.oldPkg), c.fieldType(newFld, c.newPkg); oldType != newType {
+		categories := breakingSource | breakingWireJSON
+		if !sameWireEncoding(oldFld.GetType(), newFld.GetType()) {
+			categories |= breakingWire
+		}
+		c.report(newFld, categories, "field %q on message %q changed type from %s to %s", oldFld.GetName(), msgName, oldType, newType)
+	}
+	if oldFld.GetLabel() != newFld.GetLabel() {
+		c.report(newFld, breakingAll, "field %q on message %q changed label from %s to %s", oldFld.GetName(), msgName, labelName(oldFld.GetLabel()), labelName(newFld.GetLabel()))
+	}
+	if oldOneof, newOneof := oneofName(oldFld), oneofName(newFld); oldOneof != newOneof {
+		c.report(newFld, breakingAll, "field %q on message %q changed oneof from %s to %s", oldFld.GetName(), msgName, oldOneof, newOneof)
+	}
+}
+
+func (c *breakingChecker) compareEnums(oldEds, newEds []*desc.EnumDescriptor, parent desc.Descriptor) {
+	newByName := map[string]*desc.EnumDescriptor{}
+	for _, ed := range newEds {
+		newByName[ed.GetName()] = ed
+	}
+	for _, oldEd := range oldEds {
+		newEd := newByName[oldEd.GetName()]
+		if newEd == nil {
+			c.report(parent, breakingSource, "enum %q was deleted", c.oldName(oldEd))
+			continue
+		}
+		c.compareEnum(oldEd, newEd)
+	}
+}
+
+func (c *breakingChecker) compareEnum(oldEd, newEd *desc.EnumDescriptor) {
+	enumName := c.oldName(oldEd)
+	for _, oldVal := range oldEd.GetValues() {
+		newVal := newEd.FindValueByName(oldVal.GetName())
+		if newVal != nil && newVal.GetNumber() == oldVal.GetNumber() {
+			continue
+		}
+		if byNumber := newEd.FindValueByNumber(oldVal.GetNumber()); byNumber != nil {
+			c.report(byNumber, breakingSource|breakingWireJSON, "enum value %d on enum %q changed name from %q to %q", oldVal.GetNumber(), enumName, oldVal.GetName(), byNumber.GetName())
+			continue
+		}
+		if newVal != nil {
+			c.report(newVal, breakingAll, "enum value %q on enum %q changed number from %d to %d", oldVal.GetName(), enumName, oldVal.GetNumber(), newVal.GetNumber())
+			continue
+		}
+		categories := breakingSource
+		if !isReservedEnumNumber(newEd.AsEnumDescriptorProto(), oldVal.GetNumber()) {
+			categories |= breakingWire | breakingWireJSON
+		} else if !isReservedName(newEd.AsEnumDescriptorProto().GetReservedName(), oldVal.GetName()) {
+			categories |= breakingWireJSON
+		}
+		c.report(newEd, categories, "enum value %q (%d) on enum %q was deleted", oldVal.GetName(), oldVal.GetNumber(), enumName)
+	}
+}
+
+func (c *breakingChecker) compareService(oldSd, newSd *desc.ServiceDescriptor) {
+	svcName := oldSd.GetName()
+	for _, oldMtd := range oldSd.GetMethods() {
+		newMtd := newSd.FindMethodByName(oldMtd.GetName())
+		if newMtd == nil {
+			c.report(newSd, breakingAll, "method %q on service %q was deleted", oldMtd.GetName(), svcName)
+			continue
+		}
+		if oldType, newType := c.relativeName(oldMtd.GetInputType(), c.oldPkg), c.relativeName(newMtd.GetInputType(), c.newPkg); oldType != newType {
+			c.report(newMtd, breakingAll, "method %q on service %q changed request type from %s to %s", oldMtd.GetName(), svcName, oldType, newType)
+		}
+		if oldType, newType := c.relativeName(oldMtd.GetOutputType(), c.oldPkg), c.relativeName(newMtd.GetOutputType(), c.newPkg); oldType != newType {
+			c.report(newMtd, breakingAll, "method %q on service %q changed response type from %s to %s", oldMtd.GetName(), svcName, oldType, newType)
+		}
+		if oldMtd.IsClientStreaming() != newMtd.IsClientStreaming() {
+			c.report(newMtd, breakingAll, "method %q on service %q changed client streaming from %v to %v", oldMtd.GetName(), svcName, oldMtd.IsClientStreaming(), newMtd.IsClientStreaming())
+		}
+		if oldMtd.IsServerStreaming() != newMtd.IsServerStreaming() {
+			c.report(newMtd, breakingAll, "method %q on service %q changed server streaming from %v to %v", oldMtd.GetName(), svcName, oldMtd.IsServerStreaming(), newMtd.IsServerStreaming())
+		}
+	}
+}
+
+// oldName returns the name of the given element of the old file, relative to
+// its package.
+func (c *breakingChecker) oldName(d desc.Descriptor) string {
+	return c.relativeName(d, c.oldPkg)
+}
+
+// relativeName returns the name of the given element, without the package
+// prefix if it is in the given package.
+func (c *breakingChecker) relativeName(d desc.Descriptor, pkg string) string {
+	if pkg != "" && d.GetFile().GetPackage() == pkg {
+		return strings.TrimPrefix(d.GetFullyQualifiedName(), pkg+".")
+	}
+	return d.GetFullyQualifiedName()
+}
+
+// fieldType describes the type of the given field as it would be written in
+// source.
+func (c *breakingChecker) fieldType(fld *desc.FieldDescriptor, pkg string) string {
+	switch {
+	case fld.IsMap():
+		return fmt.Sprintf("map<%s, %s>", c.fieldType(fld.GetMapKeyType(), pkg), c.fieldType(fld.GetMapValueType(), pkg))
+	case fld.GetMessageType() != nil:
+		return c.relativeName(fld.GetMessageType(), pkg)
+	case fld.GetEnumType() != nil:
+		return c.relativeName(fld.GetEnumType(), pkg)
+	default:
+		return strings.ToLower(strings.TrimPrefix(fld.GetType().String(), "TYPE_"))
+	}
+}
+
+// sameWireEncoding returns true if values of the given scalar types can be
+// read from the binary format as the other type.
+func sameWireEncoding(a, b descriptorpb.FieldDescriptorProto_Type) bool {
+	group := func(t descriptorpb.FieldDescriptorProto_Type) int {
+		switch t {
+		case descriptorpb.FieldDescriptorProto_TYPE_INT32, descriptorpb.FieldDescriptorProto_TYPE_INT64,
+			descriptorpb.FieldDescriptorProto_TYPE_UINT32, descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+			descriptorpb.FieldDescriptorProto_TYPE_BOOL, descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+			return 1
+		case descriptorpb.FieldDescriptorProto_TYPE_SINT32, descriptorpb.FieldDescriptorProto_TYPE_SINT64:
+			return 2
+		case descriptorpb.FieldDescriptorProto_TYPE_FIXED32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+			return 3
+		case descriptorpb.FieldDescriptorProto_TYPE_FIXED64, descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+			return 4
+		case descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+			return 5
+		default:
+			// floating point types and messages are only compatible with
+			// themselves, and a change in message type is never compatible
+			return -int(t)
+		}
+	}
+	if a == b && (a == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE || a == descriptorpb.FieldDescriptorProto_TYPE_GROUP) {
+		return false
+	}
+	return group(a) == group(b)
+}
+
+func labelName(label descriptorpb.FieldDescriptorProto_Label) string {
+	return strings.ToLower(strings.TrimPrefix(label.String(), "LABEL_"))
+}
+
+func oneofName(fld *desc.FieldDescriptor) string {
+	if oo := fld.GetOneOf(); oo != nil && !oo.IsSynthetic() {
+		return fmt.Sprintf("%q", oo.GetName())
+	}
+	return "none"
+}
+
+func isReservedNumber(md *descriptorpb.DescriptorProto, number int32) bool {
+	for _, rr := range md.GetReservedRange() {
+		// end is exclusive
+		if number >= rr.GetStart() && number < rr.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+func isReservedEnumNumber(ed *descriptorpb.EnumDescriptorProto, number int32) bool {
+	for _, rr := range ed.GetReservedRange() {
+		// end is inclusive
+		if number >= rr.GetStart() && number <= rr.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+func isReservedName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func qualify(pkg, name string) string {
+	if pkg == "" {
+		return name
+	}
+	return pkg + "." + name
+}
+
+// Field numbers in descriptor.proto of a file's package and syntax, as used
+// in the paths of source code info locations.
+const (
+	filePackageTag = 2
+	fileSyntaxTag  = 12
+)
+
+// sourcePos returns the position at which the given element is defined. For
+// a file, this is the position of its package statement, if it has one.
+func sourcePos(d desc.Descriptor) ast.SourcePos {
+	fileName := d.GetFile().GetName()
+	si := d.GetSourceInfo()
+	if fd, ok := d.(*desc.FileDescriptor); ok {
+		si = nil
+		for _, loc := range fd.AsFileDescriptorProto().GetSourceCodeInfo().GetLocation() {
+			if len(loc.Path) == 1 && loc.Path[0] == filePackageTag {
+				si = loc
+				break
+			}
+		}
+	}
+	if len(si.GetSpan()) < 3 {
+		return ast.UnknownPos(fileName)
+	}
+	return ast.SourcePos{Filename: fileName, Line: int(si.Span[0]) + 1, Col: int(si.Span[1]) + 1}
+}
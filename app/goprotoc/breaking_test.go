@@ -0,0 +1,209 @@
+package goprotoc
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+var breakingOldFiles = map[string]string{
+	"test.proto": `syntax = "proto3";
+package test;
+message Foo {
+  string name = 1;
+  int32 count = 2;
+  repeated string tags = 3;
+  Bar bar = 4;
+  string id = 5;
+  string old = 6;
+  string gone = 7;
+  string moved = 8;
+  int64 big = 9;
+  string pick = 11;
+}
+message Bar {}
+message Baz {}
+message Qux {}
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_A = 1;
+  KIND_B = 2;
+  KIND_C = 3;
+}
+service Svc {
+  rpc Get(Foo) returns (Foo);
+  rpc List(Foo) returns (stream Foo);
+  rpc Delete(Foo) returns (Foo);
+}
+`,
+	"gone.proto": `syntax = "proto3";
+package test.gone;
+message Req {}
+service Gone {
+  rpc Do(Req) returns (Req);
+}
+`,
+}
+
+var breakingNewFiles = map[string]string{
+	"test.proto": `syntax = "proto3";
+package test;
+message Foo {
+  reserved 6, 7;
+  reserved "old";
+  string full_name = 1 [json_name = "name"];
+  uint32 count = 2;
+  string tags = 3;
+  Baz bar = 4;
+  string id = 5 [json_name = "ID"];
+  oneof choice {
+    string moved = 10;
+    string pick = 11;
+  }
+  sint64 big = 9;
+}
+message Bar {}
+message Baz {}
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_AA = 1;
+  KIND_C = 4;
+}
+service Svc {
+  rpc Get(Bar) returns (Foo);
+  rpc List(Foo) returns (Foo);
+}
+`,
+}
+
+func TestCompareFiles(t *testing.T) {
+	parse := func(files map[string]string) *protoparse.Parser {
+		return &protoparse.Parser{Accessor: protoparse.FileContentsFromMap(files), IncludeSourceCodeInfo: true}
+	}
+	oldFds, err := parse(breakingOldFiles).ParseFiles("test.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFds, err := parse(breakingNewFiles).ParseFiles("test.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		categories breakingCategory
+		pos        string
+		message    string
+	}{
+		{breakingSource, "test.proto:6:3", `field 1 on message "Foo" changed name from "name" to "full_name"`},
+		{breakingSource | breakingWireJSON, "test.proto:7:3", `field "count" on message "Foo" changed type from int32 to uint32`},
+		{breakingAll, "test.proto:8:3", `field "tags" on message "Foo" changed label from repeated to optional`},
+		{breakingAll, "test.proto:9:3", `field "bar" on message "Foo" changed type from Bar to Baz`},
+		{breakingWireJSON, "test.proto:10:3", `field "id" on message "Foo" changed JSON name from "id" to "ID"`},
+		{breakingSource, "test.proto:3:1", `field "old" (6) on message "Foo" was deleted`},
+		{breakingSource | breakingWireJSON, "test.proto:3:1", `field "gone" (7) on message "Foo" was deleted`},
+		{breakingAll, "test.proto:12:5", `field "moved" on message "Foo" changed number from 8 to 10`},
+		{breakingAll, "test.proto:15:3", `field "big" on message "Foo" changed type from int64 to sint64`},
+		{breakingAll, "test.proto:13:5", `field "pick" on message "Foo" changed oneof from none to "choice"`},
+		{breakingSource, "test.proto:2:1", `message "Qux" was deleted`},
+		{breakingSource | breakingWireJSON, "test.proto:21:3", `enum value 1 on enum "Kind" changed name from "KIND_A" to "KIND_AA"`},
+		{breakingAll, "test.proto:19:1", `enum value "KIND_B" (2) on enum "Kind" was deleted`},
+		{breakingAll, "test.proto:22:3", `enum value "KIND_C" on enum "Kind" changed number from 3 to 4`},
+		{breakingAll, "test.proto:25:3", `method "Get" on service "Svc" changed request type from Foo to Bar`},
+		{breakingAll, "test.proto:26:3", `method "List" on service "Svc" changed server streaming from true to false`},
+		{breakingAll, "test.proto:24:1", `method "Delete" on service "Svc" was deleted`},
+	}
+	changes := compareFiles(oldFds[0], newFds[0])
+	for i, change := range changes {
+		if i >= len(expected) {
+			t.Errorf("unexpected change: %s", change.message)
+			continue
+		}
+		if change.message != expected[i].message {
+			t.Errorf("change %d: expected %q, got %q", i, expected[i].message, change.message)
+		}
+		if pos := change.pos.String(); pos != expected[i].pos {
+			t.Errorf("%s: expected position %s, got %s", change.message, expected[i].pos, pos)
+		}
+		if change.categories != expected[i].categories {
+			t.Errorf("%s: expected categories %b, got %b", change.message, expected[i].categories, change.categories)
+		}
+	}
+	for i := len(changes); i < len(expected); i++ {
+		t.Errorf("missing change: %s", expected[i].message)
+	}
+
+	// renaming the package must not cause every element to be reported
+	newFds, err = parse(map[string]string{"test.proto": strings.Replace(breakingOldFiles["test.proto"], "package test;", "package test.v2;", 1)}).ParseFiles("test.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes = compareFiles(oldFds[0], newFds[0])
+	if len(changes) != 1 || changes[0].message != `package changed from "test" to "test.v2"` {
+		t.Errorf("renaming the package should only be reported once, got %v", changes)
+	}
+}
+
+func TestRunBreaking(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, filepath.Join(dir, "old"), breakingOldFiles)
+	writeTestFiles(t, filepath.Join(dir, "new"), breakingNewFiles)
+	oldSet := filepath.Join(dir, "old.bin")
+	oldFds, err := (&protoparse.Parser{ImportPaths: []string{filepath.Join(dir, "old")}}).ParseFiles("test.proto", "gone.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveDescriptor(oldSet, oldFds, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, against := range []string{filepath.Join(dir, "old"), oldSet} {
+		var stdout, stderr bytes.Buffer
+		args := []string{"goprotoc", "breaking", "--against", against, "--category=WIRE", "-I", filepath.Join(dir, "new"), "test.proto"}
+		err := run(context.Background(), args, nil, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("expected breaking changes")
+		}
+		lines := strings.Split(err.Error(), "\n")
+		if len(lines) != 11 {
+			t.Errorf("expected 11 wire-breaking changes, got %d:\n%s", len(lines), err)
+		}
+		if lines[0] != `test.proto:8:3: field "tags" on message "Foo" changed label from repeated to optional` {
+			t.Errorf("wrong first change: %s", lines[0])
+		}
+		// a deleted file is only a source-breaking change, but deleting its
+		// services also breaks the wire format
+		if last := lines[len(lines)-1]; last != `gone.proto: service "Gone" was deleted` {
+			t.Errorf("wrong last change: %s", last)
+		}
+
+		args = []string{"goprotoc", "breaking", "--against", against, "-I", filepath.Join(dir, "new"), "test.proto"}
+		err = run(context.Background(), args, nil, &stdout, &stderr)
+		if err == nil || !strings.HasSuffix(err.Error(), "\ngone.proto: file \"gone.proto\" was deleted\ngone.proto: service \"Gone\" was deleted") {
+			t.Errorf("expected deleted file to be reported, got %v", err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"goprotoc", "breaking", "--against=" + filepath.Join(dir, "old"), "--error_format=json", "-I", filepath.Join(dir, "old"), "test.proto"}
+	if err := run(context.Background(), args, nil, &stdout, &stderr); err != nil {
+		t.Errorf("comparing a file with itself should not report changes: %v", err)
+	}
+	args = []string{"goprotoc", "breaking", "--against=" + filepath.Join(dir, "old"), "--error_format=json", "-I", filepath.Join(dir, "new"), "test.proto"}
+	err = run(context.Background(), args, nil, &stdout, &stderr)
+	if err == nil || !strings.HasPrefix(err.Error(), `{"file":"test.proto","line":6,"column":3,"severity":"error","message":"field 1 on message \"Foo\" changed name from \"name\" to \"full_name\""}`) {
+		t.Errorf("wrong JSON output: %v", err)
+	}
+
+	args = []string{"goprotoc", "breaking", "-I", filepath.Join(dir, "new"), "test.proto"}
+	if err := run(context.Background(), args, nil, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "requires --against") {
+		t.Errorf("expected error about missing --against, got %v", err)
+	}
+	args = []string{"goprotoc", "breaking", "--against=" + oldSet, "--category=BINARY", "-I", filepath.Join(dir, "new"), "test.proto"}
+	if err := run(context.Background(), args, nil, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "Unknown breaking change category: BINARY") {
+		t.Errorf("expected error about unknown category, got %v", err)
+	}
+}
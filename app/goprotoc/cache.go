@@ -0,0 +1,264 @@
+package goprotoc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// defaultCacheMaxSize is the default limit on the total size of the entries
+// in the codegen cache: 1 GiB.
+const defaultCacheMaxSize = 1 << 30
+
+// cacheKeyVersion is included in every cache key. It should be changed if the
+// format of cache entries changes, to invalidate all existing entries.
+const cacheKeyVersion = "goprotoc-cache-v1"
+
+// codeGenCache is an on-disk cache of plugin responses. Entries are keyed by a
+// hash of the request sent to the plugin and of the plugin executable, so a
+// cached response is only used if the same plugin binary is given exactly the
+// same files and parameters.
+type codeGenCache struct {
+	dir     string
+	maxSize int64
+
+	mu           sync.Mutex
+	pluginHashes map[string]string
+}
+
+func newCodeGenCache(dir string, maxSize int64) (*codeGenCache, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	if maxSize <= 0 {
+		maxSize = defaultCacheMaxSize
+	}
+	return &codeGenCache{dir: dir, maxSize: maxSize, pluginHashes: map[string]string{}}, nil
+}
+
+// key computes the cache key for running the given plugin with the given
+// request.
+func (c *codeGenCache) key(pluginPath string, reqpb *pluginpb.CodeGeneratorRequest) (string, error) {
+	pluginHash, err := c.hashPlugin(pluginPath)
+	if err != nil {
+		return "", err
+	}
+	reqBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(reqpb)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\n%s\n", cacheKeyVersion, pluginHash)
+	_, _ = h.Write(reqBytes)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashPlugin computes the hash of the given plugin's executable. Results are
+// memoized since multiple outputs may use the same plugin.
+func (c *codeGenCache) hashPlugin(pluginPath string) (string, error) {
+	resolved, err := exec.LookPath(pluginPath)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err = filepath.Abs(resolved); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h, ok := c.pluginHashes[resolved]; ok {
+		return h, nil
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	c.pluginHashes[resolved] = hash
+	return hash, nil
+}
+
+func (c *codeGenCache) entryPath(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// get returns the cached response for the given key. If there is no such entry,
+// or it cannot be read, it returns false.
+func (c *codeGenCache) get(key string) (*pluginpb.CodeGeneratorResponse, bool) {
+	fileName := c.entryPath(key)
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, false
+	}
+	var respb pluginpb.CodeGeneratorResponse
+	if err := proto.Unmarshal(data, &respb); err != nil {
+		// corrupt entry; remove it so it will be replaced
+		_ = os.Remove(fileName)
+		return nil, false
+	}
+	// Update the modification time, which is used to decide which entries
+	// to evict first. Failure is harmless, so the error is ignored.
+	now := time.Now()
+	_ = os.Chtimes(fileName, now, now)
+	return &respb, true
+}
+
+// put stores the given response in the cache.
+func (c *codeGenCache) put(key string, respb *pluginpb.CodeGeneratorResponse) error {
+	data, err := proto.Marshal(respb)
+	if err != nil {
+		return err
+	}
+	fileName := c.entryPath(key)
+	if err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomically(fileName, data)
+}
+
+// prune removes the least recently used entries until the total size of the
+// entries in the cache is no more than the cache's maximum size. Only files
+// laid out like cache entries are considered, so any other files in the cache
+// directory are left alone.
+func (c *codeGenCache) prune() error {
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	var total int64
+	dirs, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || !isCacheDirName(d.Name()) {
+			continue
+		}
+		dir := filepath.Join(c.dir, d.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// concurrently removed
+				continue
+			}
+			return err
+		}
+		for _, f := range files {
+			// this also skips any temp files that are being written
+			if !f.Type().IsRegular() || !isCacheEntryName(d.Name(), f.Name()) {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					// concurrently removed
+					continue
+				}
+				return err
+			}
+			entries = append(entries, entry{path: filepath.Join(dir, f.Name()), size: info.Size(), modTime: info.ModTime()})
+			total += info.Size()
+		}
+	}
+	if total <= c.maxSize {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, e := range entries {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+// isCacheDirName returns true if the given name is that of a sub-directory of
+// the cache, which is named with the first two hex digits of its entries' keys.
+func isCacheDirName(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// isCacheEntryName returns true if the given name is that of a cache entry in
+// the given sub-directory of the cache. Entries are named with their keys,
+// which are hex-encoded SHA-256 hashes.
+func isCacheEntryName(dirName, name string) bool {
+	if len(name) != sha256.Size*2 || !strings.HasPrefix(name, dirName) {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// clearCache removes all entries from the cache in the given directory.
+func clearCache(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !isCacheDirName(e.Name()) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSize parses a size in bytes, with an optional suffix of K, M, or G for
+// kibibytes, mebibytes, or gibibytes.
+func parseSize(size string) (int64, error) {
+	s := size
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size: %q", size)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,153 @@
+package goprotoc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhump/goprotoc/plugins"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestCodeGenCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script as a plugin")
+	}
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto": `syntax = "proto3"; message Foo {}`,
+	}, "test.proto")
+
+	dir := t.TempDir()
+	// this plugin records each invocation and returns an empty response
+	counter := filepath.Join(dir, "count")
+	pluginPath := filepath.Join(dir, "protoc-gen-count")
+	script := "#!/bin/sh\ncat > /dev/null\necho x >> " + counter + "\n"
+	if err := os.WriteFile(pluginPath, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := newCodeGenCache(filepath.Join(dir, "cache"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) {
+		t.Helper()
+		req := plugins.CodeGenRequest{Files: fds, Args: args, ProtocVersion: protocVersionStruct}
+		if err := execPlugin(context.Background(), pluginPath, &req, plugins.NewCodeGenResponse("count", nil), nil, cache); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkCount := func(expected int) {
+		t.Helper()
+		data, err := os.ReadFile(counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := strings.Count(string(data), "x"); actual != expected {
+			t.Errorf("wrong number of plugin invocations: expected %d, got %d", expected, actual)
+		}
+	}
+	run()
+	run()
+	checkCount(1)
+	run("foo")
+	checkCount(2)
+	run("foo")
+	checkCount(2)
+
+	// changing the plugin binary invalidates the cache
+	if err := os.WriteFile(pluginPath, []byte(script+"# changed\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	cache.pluginHashes = map[string]string{}
+	run()
+	checkCount(3)
+
+	if err := clearCache(cache.dir); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	checkCount(4)
+}
+
+func TestCodeGenCache_Prune(t *testing.T) {
+	cache, err := newCodeGenCache(t.TempDir(), 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respb := &pluginpb.CodeGeneratorResponse{
+		File: []*pluginpb.CodeGeneratorResponse_File{{Name: proto.String("a.txt"), Content: proto.String(strings.Repeat("a", 90))}},
+	}
+	a, b, c := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	for i, key := range []string{a, b, c} {
+		if err := cache.put(key, respb); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err := os.Chtimes(cache.entryPath(key), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// using an entry makes it the most recently used
+	if _, ok := cache.get(a); !ok {
+		t.Fatal("entry a should be present")
+	}
+	if err := cache.prune(); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]bool{a: true, b: false, c: true} {
+		if _, err := os.Stat(cache.entryPath(key)); (err == nil) != expected {
+			t.Errorf("%s: expected present=%v, got error %v", key[:1], expected, err)
+		}
+	}
+}
+
+func TestCodeGenCache_PruneOnlyEntries(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := newCodeGenCache(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// files that are not laid out like cache entries, all older than the entry
+	foreign := []string{
+		"notes.txt",
+		filepath.Join(".git", "objects", "abc"),
+		filepath.Join("aa", "notes.txt"),
+		filepath.Join("aa", strings.Repeat("b", 64)),
+		filepath.Join("src", "aa", strings.Repeat("a", 64)),
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, name := range foreign {
+		fileName := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fileName, []byte("do not delete"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fileName, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := strings.Repeat("a", 64)
+	if err := cache.put(key, &pluginpb.CodeGeneratorResponse{Error: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.prune(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cache.entryPath(key)); !os.IsNotExist(err) {
+		t.Errorf("cache entry should have been pruned, got error %v", err)
+	}
+	for _, name := range foreign {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s should not have been pruned: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package goprotoc
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// outputStatus describes how a generated output compares with what is on disk.
+type outputStatus int
+
+const (
+	outputUpToDate outputStatus = iota
+	outputStale
+	outputMissing
+	outputExtra
+)
+
+func (s outputStatus) String() string {
+	switch s {
+	case outputStale:
+		return "is out of date"
+	case outputMissing:
+		return "is missing"
+	case outputExtra:
+		return "should not exist"
+	default:
+		return "is up to date"
+	}
+}
+
+// outputCheck is the result of comparing one output file with what is on disk.
+type outputCheck struct {
+	name         string
+	status       outputStatus
+	onDisk, want []byte
+}
+
+// checkOutputs compares the given generated outputs with the files on disk.
+// It returns an error that describes every output that is stale, missing, or
+// extra. Extra files are entries in archives that would not be generated and
+// the given files, which were generated by a previous run but would not be
+// generated now (see findStaleOutputs). Such files are only known from the
+// manifests written by --remove_stale, so extra is empty without it. If diff
+// is true, a unified diff of those outputs is also written to w.
+func checkOutputs(results map[outputFile]io.Reader, extra []string, diff bool, w io.Writer) error {
+	var checks []outputCheck
+	for _, fileName := range extra {
+		onDisk, err := os.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		checks = append(checks, outputCheck{name: displayPath(fileName), status: outputExtra, onDisk: onDisk})
+	}
+	archiveResults := map[outputLocation]map[string]io.Reader{}
+	for file, data := range results {
+		if file.loc.locationType != outputTypeDir {
+			archiveFiles := archiveResults[file.loc]
+			if archiveFiles == nil {
+				archiveFiles = map[string]io.Reader{}
+				archiveResults[file.loc] = archiveFiles
+			}
+			archiveFiles[file.fileName] = data
+			continue
+		}
+		want, err := io.ReadAll(data)
+		if err != nil {
+			return err
+		}
+		fileName := filepath.Join(file.loc.path, file.fileName)
+		check := outputCheck{name: displayPath(fileName), want: want}
+		onDisk, err := os.ReadFile(fileName)
+		switch {
+		case os.IsNotExist(err):
+			check.status = outputMissing
+		case err != nil:
+			return err
+		case !bytes.Equal(onDisk, want):
+			check.status = outputStale
+			check.onDisk = onDisk
+		}
+		checks = append(checks, check)
+	}
+	for location, files := range archiveResults {
+		archiveChecks, err := checkArchive(location.path, location.locationType == outputTypeJar, files)
+		if err != nil {
+			return err
+		}
+		checks = append(checks, archiveChecks...)
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+
+	var errs []error
+	for _, check := range checks {
+		if check.status == outputUpToDate {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: %v", check.name, check.status))
+		if !diff {
+			continue
+		}
+		nameA, nameB := check.name, check.name
+		switch check.status {
+		case outputMissing:
+			nameA = "/dev/null"
+		case outputExtra:
+			nameB = "/dev/null"
+		}
+		if _, err := io.WriteString(w, unifiedDiff(nameA, nameB, check.onDisk, check.want)); err != nil {
+			return err
+		}
+	}
+	return toError(errs)
+}
+
+// checkArchive compares the given files with the entries in the archive at the
+// given path.
+func checkArchive(fileName string, includeManifest bool, files map[string]io.Reader) ([]outputCheck, error) {
+	want := make(map[string][]byte, len(files)+1)
+	if includeManifest {
+		want["META-INF/MANIFEST.MF"] = manifestContents
+	}
+	for name, data := range files {
+		b, err := io.ReadAll(data)
+		if err != nil {
+			return nil, err
+		}
+		want[name] = b
+	}
+
+	onDisk := map[string][]byte{}
+	z, err := zip.OpenReader(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer func() {
+			_ = z.Close()
+		}()
+		for _, f := range z.File {
+			b, err := readZipEntry(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", fileName, err)
+			}
+			onDisk[f.Name] = b
+		}
+	}
+
+	archiveName := displayPath(fileName)
+	var checks []outputCheck
+	for name, b := range want {
+		check := outputCheck{name: fmt.Sprintf("%s:%s", archiveName, name), want: b}
+		existing, ok := onDisk[name]
+		switch {
+		case !ok:
+			check.status = outputMissing
+		case !bytes.Equal(existing, b):
+			check.status = outputStale
+			check.onDisk = existing
+		}
+		checks = append(checks, check)
+	}
+	for name, b := range onDisk {
+		if _, ok := want[name]; !ok {
+			checks = append(checks, outputCheck{
+				name:   fmt.Sprintf("%s:%s", archiveName, name),
+				status: outputExtra,
+				onDisk: b,
+			})
+		}
+	}
+	return checks, nil
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	return io.ReadAll(r)
+}
+
+// displayPath returns the given absolute path relative to the current working
+// directory, if it is inside the working directory. Otherwise, it returns the
+// path unchanged.
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
@@ -5,38 +5,88 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
-func doEncode(encodeType string, fds []*desc.FileDescriptor, r io.Reader, w io.Writer) error {
-	var md *desc.MessageDescriptor
-	for _, fd := range fds {
-		md = fd.FindMessage(encodeType)
-		if md != nil {
-			break
-		}
+// messageFormat is a format for encoding a message.
+type messageFormat int
+
+const (
+	messageFormatDefault messageFormat = iota
+	messageFormatBinary
+	messageFormatText
+	messageFormatJSON
+)
+
+func parseMessageFormat(s string) (messageFormat, error) {
+	switch s {
+	case "binary":
+		return messageFormatBinary, nil
+	case "text":
+		return messageFormatText, nil
+	case "json":
+		return messageFormatJSON, nil
+	default:
+		return 0, fmt.Errorf("unknown message format %q: must be 'binary', 'text', or 'json'", s)
 	}
-	if md == nil {
-		return fmt.Errorf("type not defined: %s", encodeType)
+}
+
+// codecOptions control how messages are converted by --encode and --decode.
+// The encoded format is what --encode writes and --decode reads; it defaults
+// to binary. The decoded format is what --encode reads and --decode writes;
+// it defaults to text.
+type codecOptions struct {
+	encodedFormat     messageFormat
+	decodedFormat     messageFormat
+	jsonEmitDefaults  bool
+	jsonUseProtoNames bool
+	// delimited indicates that the encoded data is a stream of binary
+	// messages, each preceded by its length as a varint.
+	delimited bool
+}
+
+func (o *codecOptions) getEncodedFormat() messageFormat {
+	if o.encodedFormat == messageFormatDefault {
+		return messageFormatBinary
 	}
+	return o.encodedFormat
+}
 
-	var er dynamic.ExtensionRegistry
-	for _, fd := range fds {
-		er.AddExtensionsFromFileRecursively(fd)
+func (o *codecOptions) getDecodedFormat() messageFormat {
+	if o.decodedFormat == messageFormatDefault {
+		return messageFormatText
 	}
-	dm := dynamic.NewMessageWithExtensionRegistry(md, &er)
+	return o.decodedFormat
+}
+
+func doEncode(encodeType string, fds []*desc.FileDescriptor, opts *codecOptions, r io.Reader, w io.Writer) error {
+	c, err := newMessageCodec(encodeType, fds, opts)
+	if err != nil {
+		return err
+	}
+	if opts.delimited {
+		return c.encodeDelimited(r, w)
+	}
+	dm := c.newMessage()
 
 	b, err := io.ReadAll(r)
 	if err != nil {
 		return fmt.Errorf("failed to read input: %v", err)
 	}
-	if err := dm.UnmarshalText(b); err != nil {
+	if err := c.unmarshal(dm, b, opts.getDecodedFormat()); err != nil {
 		return fmt.Errorf("failed to parse input: %v", err)
 	}
-	b, err = dm.Marshal()
+	b, err = c.marshal(dm, opts.getEncodedFormat())
 	if err != nil {
 		return fmt.Errorf("failed to serialize message: %v", err)
 	}
@@ -47,52 +97,168 @@ func doEncode(encodeType string, fds []*desc.FileDescriptor, r io.Reader, w io.W
 	return nil
 }
 
-func doDecode(decodeType string, fds []*desc.FileDescriptor, r io.Reader, w io.Writer) error {
+func doDecode(decodeType string, fds []*desc.FileDescriptor, opts *codecOptions, r io.Reader, w io.Writer) error {
+	c, err := newMessageCodec(decodeType, fds, opts)
+	if err != nil {
+		return err
+	}
+	if opts.delimited {
+		return c.decodeDelimited(r, w)
+	}
+	dm := c.newMessage()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %v", err)
+	}
+	if err := c.unmarshal(dm, b, opts.getEncodedFormat()); err != nil {
+		return fmt.Errorf("failed to parse input: %v", err)
+	}
+	b, err = c.marshal(dm, opts.getDecodedFormat())
+	if err != nil {
+		return fmt.Errorf("failed to format message: %v", err)
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		return fmt.Errorf("failed to write decoded message: %v", err)
+	}
+	return nil
+}
+
+// messageCodec converts messages of a particular type between formats.
+type messageCodec struct {
+	md    *desc.MessageDescriptor
+	mf    *dynamic.MessageFactory
+	types *dynamicpb.Types
+	opts  *codecOptions
+}
+
+func newMessageCodec(msgType string, fds []*desc.FileDescriptor, opts *codecOptions) (*messageCodec, error) {
 	var md *desc.MessageDescriptor
 	for _, fd := range fds {
-		md = fd.FindMessage(decodeType)
+		md = fd.FindMessage(msgType)
 		if md != nil {
 			break
 		}
 	}
 	if md == nil {
-		return fmt.Errorf("type not defined: %s", decodeType)
+		return nil, fmt.Errorf("type not defined: %s", msgType)
 	}
 
 	var er dynamic.ExtensionRegistry
 	for _, fd := range fds {
 		er.AddExtensionsFromFileRecursively(fd)
 	}
-	dm := dynamic.NewMessageWithExtensionRegistry(md, &er)
+	// JSON format needs to be able to resolve message types in order to
+	// process google.protobuf.Any messages, so we need a registry of all
+	// of the files.
+	var files protoregistry.Files
+	for _, fd := range fds {
+		if err := registerFileRecursively(&files, fd); err != nil {
+			return nil, err
+		}
+	}
+	return &messageCodec{
+		md:    md,
+		mf:    dynamic.NewMessageFactoryWithExtensionRegistry(&er),
+		types: dynamicpb.NewTypes(&files),
+		opts:  opts,
+	}, nil
+}
 
-	b, err := io.ReadAll(r)
-	if err != nil {
-		return fmt.Errorf("failed to read input: %v", err)
+func registerFileRecursively(files *protoregistry.Files, fd *desc.FileDescriptor) error {
+	if _, err := files.FindFileByPath(fd.GetName()); err == nil {
+		// already registered
+		return nil
 	}
-	if err := dm.Unmarshal(b); err != nil {
-		return fmt.Errorf("failed to parse input: %v", err)
+	for _, dep := range fd.GetDependencies() {
+		if err := registerFileRecursively(files, dep); err != nil {
+			return err
+		}
 	}
-	b, err = dm.MarshalTextIndent()
-	if err != nil {
-		return fmt.Errorf("failed to format message: %v", err)
+	return files.RegisterFile(fd.UnwrapFile())
+}
+
+func (c *messageCodec) newMessage() *dynamic.Message {
+	return c.mf.NewDynamicMessage(c.md)
+}
+
+func (c *messageCodec) unmarshal(dm *dynamic.Message, data []byte, format messageFormat) error {
+	switch format {
+	case messageFormatText:
+		return dm.UnmarshalText(data)
+	case messageFormatJSON:
+		msg := dynamicpb.NewMessage(c.md.UnwrapMessage())
+		if err := (protojson.UnmarshalOptions{Resolver: c.types}).Unmarshal(data, msg); err != nil {
+			return err
+		}
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return dm.Unmarshal(b)
+	default:
+		return dm.Unmarshal(data)
 	}
-	_, err = w.Write(b)
-	if err != nil {
-		return fmt.Errorf("failed to write decoded message: %v", err)
+}
+
+func (c *messageCodec) marshal(dm *dynamic.Message, format messageFormat) ([]byte, error) {
+	switch format {
+	case messageFormatText:
+		return dm.MarshalTextIndent()
+	case messageFormatJSON:
+		b, err := dm.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		msg := dynamicpb.NewMessage(c.md.UnwrapMessage())
+		if err := (proto.UnmarshalOptions{Resolver: c.types}).Unmarshal(b, msg); err != nil {
+			return nil, err
+		}
+		b, err = protojson.MarshalOptions{
+			Multiline:       true,
+			Indent:          "  ",
+			EmitUnpopulated: c.opts.jsonEmitDefaults,
+			UseProtoNames:   c.opts.jsonUseProtoNames,
+			Resolver:        c.types,
+		}.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	default:
+		return dm.Marshal()
 	}
-	return nil
 }
 
-func doDecodeRaw(r io.Reader, w io.Writer) error {
+func doDecodeRaw(r io.Reader, w io.Writer, verbose bool) error {
 	data, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
 	in := newCodedReader(data)
-	return decodeRawMessage(in, w, "", false)
+	d := rawDecoder{w: w, verbose: verbose}
+	return d.decodeMessage(in, 0, "", false)
 }
 
 func decodeRawMessage(in *codedReader, w io.Writer, indent string, inGroup bool) error {
+	d := rawDecoder{w: w}
+	return d.decodeMessage(in, 0, indent, inGroup)
+}
+
+// rawDecoder writes the raw tag/value pairs of encoded messages. When verbose,
+// every field is annotated with a comment that shows its location in the input
+// and all of the ways its value could be interpreted.
+type rawDecoder struct {
+	w       io.Writer
+	verbose bool
+}
+
+// decodeMessage decodes the fields in the given reader. The given base is the
+// offset of the start of in's buffer in the original input, so that reported
+// offsets are relative to the start of the input.
+func (d *rawDecoder) decodeMessage(in *codedReader, base int, indent string, inGroup bool) error {
+	w := d.w
 	for {
 		if in.eof() {
 			if inGroup {
@@ -100,6 +266,7 @@ func decodeRawMessage(in *codedReader, w io.Writer, indent string, inGroup bool)
 			}
 			return nil
 		}
+		fieldStart := in.index
 		t, wt, err := in.decodeTagAndWireType()
 		if err != nil {
 			return err
@@ -122,7 +289,8 @@ func decodeRawMessage(in *codedReader, w io.Writer, indent string, inGroup bool)
 			if err != nil {
 				return err
 			}
-			if _, err := fmt.Fprintf(w, "%s%d: %d\n", indent, t, v); err != nil {
+			comment := d.comment(base+fieldStart, in.index-fieldStart, describeVarint(v))
+			if _, err := fmt.Fprintf(w, "%s%d: %d%s\n", indent, t, v, comment); err != nil {
 				return err
 			}
 		case protowire.Fixed32Type:
@@ -131,7 +299,8 @@ func decodeRawMessage(in *codedReader, w io.Writer, indent string, inGroup bool)
 				return err
 			}
 			f := math.Float32frombits(uint32(v))
-			if _, err := fmt.Fprintf(w, "%s%d: %f\n", indent, t, f); err != nil {
+			comment := d.comment(base+fieldStart, in.index-fieldStart, describeFixed32(uint32(v)))
+			if _, err := fmt.Fprintf(w, "%s%d: %f%s\n", indent, t, f, comment); err != nil {
 				return err
 			}
 		case protowire.Fixed64Type:
@@ -140,7 +309,8 @@ func decodeRawMessage(in *codedReader, w io.Writer, indent string, inGroup bool)
 				return err
 			}
 			f := math.Float64frombits(v)
-			if _, err := fmt.Fprintf(w, "%s%d: %f\n", indent, t, f); err != nil {
+			comment := d.comment(base+fieldStart, in.index-fieldStart, describeFixed64(v))
+			if _, err := fmt.Fprintf(w, "%s%d: %f%s\n", indent, t, f, comment); err != nil {
 				return err
 			}
 		case protowire.BytesType:
@@ -148,31 +318,44 @@ func decodeRawMessage(in *codedReader, w io.Writer, indent string, inGroup bool)
 			if err != nil {
 				return err
 			}
+			valueStart := in.index - len(v)
 			if isProbablyMessage(v) {
-				if _, err := fmt.Fprintf(w, "%s%d: <\n", indent, t); err != nil {
+				comment := d.comment(base+fieldStart, in.index-fieldStart, "")
+				if _, err := fmt.Fprintf(w, "%s%d: <%s\n", indent, t, comment); err != nil {
 					return err
 				}
 				nested := newCodedReader(v)
-				if err := decodeRawMessage(nested, w, indent+"  ", false); err != nil {
+				if err := d.decodeMessage(nested, base+valueStart, indent+"  ", false); err != nil {
 					return err
 				}
 				if _, err := fmt.Fprintf(w, "%s>\n", indent); err != nil {
 					return err
 				}
 			} else if isProbablyString(v) {
-				if _, err := fmt.Fprintf(w, "%s%d: %s\n", indent, t, quoteString(v)); err != nil {
+				comment := d.comment(base+fieldStart, in.index-fieldStart, describePacked(v, true))
+				if _, err := fmt.Fprintf(w, "%s%d: %s%s\n", indent, t, quoteString(v), comment); err != nil {
 					return err
 				}
 			} else {
-				if _, err := fmt.Fprintf(w, "%s%d: %s\n", indent, t, quoteBytes(v)); err != nil {
+				comment := d.comment(base+fieldStart, in.index-fieldStart, describePacked(v, false))
+				if _, err := fmt.Fprintf(w, "%s%d: %s%s\n", indent, t, quoteBytes(v), comment); err != nil {
 					return err
 				}
 			}
 		case protowire.StartGroupType:
-			if _, err := fmt.Fprintf(w, "%s%d {\n", indent, t); err != nil {
+			var comment string
+			if d.verbose {
+				// find the end of the group so we can report its length
+				end := *in
+				if !end.isProbablyMessage(true) {
+					return io.ErrUnexpectedEOF
+				}
+				comment = d.comment(base+fieldStart, end.index-fieldStart, "")
+			}
+			if _, err := fmt.Fprintf(w, "%s%d {%s\n", indent, t, comment); err != nil {
 				return err
 			}
-			if err := decodeRawMessage(in, w, indent+"  ", true); err != nil {
+			if err := d.decodeMessage(in, base, indent+"  ", true); err != nil {
 				return err
 			}
 			if _, err := fmt.Fprintf(w, "%s}\n", indent); err != nil {
@@ -185,6 +368,55 @@ func decodeRawMessage(in *codedReader, w io.Writer, indent string, inGroup bool)
 	}
 }
 
+// comment returns the annotation for a field when verbose, which includes the
+// field's offset and length (including its tag) and the given description. If
+// not verbose, it returns the empty string.
+func (d *rawDecoder) comment(offset, length int, description string) string {
+	if !d.verbose {
+		return ""
+	}
+	if description == "" {
+		return fmt.Sprintf("  # offset=%d len=%d", offset, length)
+	}
+	return fmt.Sprintf("  # offset=%d len=%d %s", offset, length, description)
+}
+
+func describeVarint(v uint64) string {
+	return fmt.Sprintf("int=%d zigzag=%d uint=%d hex=0x%x", int64(v), protowire.DecodeZigZag(v), v, v)
+}
+
+func describeFixed32(v uint32) string {
+	return fmt.Sprintf("float=%g int=%d uint=%d hex=0x%08x", math.Float32frombits(v), int32(v), v, v)
+}
+
+func describeFixed64(v uint64) string {
+	return fmt.Sprintf("double=%g int=%d uint=%d hex=0x%016x", math.Float64frombits(v), int64(v), v, v)
+}
+
+// describePacked returns a description of the given bytes as a packed
+// sequence of varints, or the empty string if they are not a valid sequence
+// of varints. Text, which is usually also a valid sequence of varints, is
+// not described unless it contains control characters.
+func describePacked(data []byte, isString bool) string {
+	if len(data) == 0 || (isString && !bytes.ContainsFunc(data, isControlRune)) {
+		return ""
+	}
+	in := newCodedReader(data)
+	var vals []string
+	for !in.eof() {
+		v, err := in.decodeVarint()
+		if err != nil {
+			return ""
+		}
+		vals = append(vals, strconv.FormatUint(v, 10))
+	}
+	return fmt.Sprintf("packed=[%s]", strings.Join(vals, ", "))
+}
+
+func isControlRune(r rune) bool {
+	return unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t'
+}
+
 func quoteString(s []byte) string {
 	// strings.Builder returns nil error for all Write* methods,
 	// so we ignore error return values in method calls below
@@ -0,0 +1,116 @@
+package goprotoc
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+func parseTestFiles(t *testing.T, contents map[string]string, names ...string) []*desc.FileDescriptor {
+	p := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(contents)}
+	fds, err := p.ParseFiles(names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fds
+}
+
+func TestEncodeDecode_JSON(t *testing.T) {
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto": `
+			syntax = "proto3";
+			import "google/protobuf/any.proto";
+			message Inner { string name = 1; }
+			message Outer { int32 some_id = 1; google.protobuf.Any payload = 2; }`,
+	}, "test.proto")
+
+	jsonIn := `{"someId": 5, "payload": {"@type": "type.googleapis.com/Inner", "name": "foo"}}`
+	var encoded bytes.Buffer
+	opts := codecOptions{decodedFormat: messageFormatJSON}
+	if err := doEncode("Outer", fds, &opts, strings.NewReader(jsonIn), &encoded); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded bytes.Buffer
+	opts.jsonUseProtoNames = true
+	if err := doDecode("Outer", fds, &opts, &encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "some_id": 5,
+  "payload": {
+    "@type": "type.googleapis.com/Inner",
+    "name": "foo"
+  }
+}
+`
+	// protojson randomly varies whitespace, so we normalize it
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	if normalize(decoded.String()) != normalize(expected) {
+		t.Errorf("wrong decoded output:\nexpected:\n%s\ngot:\n%s", expected, decoded.String())
+	}
+}
+
+func TestEncodeDecode_Delimited(t *testing.T) {
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto": `
+			syntax = "proto3";
+			message Foo { string name = 1; int32 id = 2; }`,
+	}, "test.proto")
+
+	textIn := "name: \"abc\"\nid: 1\n\n\nname: \"def\"\n\nid: 3\n"
+	var encoded bytes.Buffer
+	opts := codecOptions{delimited: true}
+	if err := doEncode("Foo", fds, &opts, strings.NewReader(textIn), &encoded); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+
+	var decoded bytes.Buffer
+	opts.decodedFormat = messageFormatJSON
+	if err := doDecode("Foo", fds, &opts, bytes.NewReader(data), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	normalized := strings.Join(strings.Fields(decoded.String()), " ")
+	if expected := `{ "name": "abc", "id": 1 } { "name": "def" } { "id": 3 }`; normalized != expected {
+		t.Errorf("wrong decoded output: expected %s, got %s", expected, normalized)
+	}
+
+	// truncate the last record
+	err := doDecode("Foo", fds, &opts, bytes.NewReader(data[:len(data)-1]), io.Discard)
+	if err == nil {
+		t.Fatal("expecting error for truncated input")
+	}
+	if expected := "failed to read record 2 at offset 14: unexpected EOF"; err.Error() != expected {
+		t.Errorf("wrong error: expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestDecodeRaw_Verbose(t *testing.T) {
+	data := []byte{
+		0x08, 0x05, // 1: varint 5
+		0x15, 0x00, 0x00, 0x80, 0x3f, // 2: fixed32 1.0
+		0x1a, 0x03, 0x01, 0x96, 0x01, // 3: bytes, packed [1, 150]
+		0x22, 0x02, 0x08, 0x01, // 4: message { 1: 1 }
+	}
+	var out bytes.Buffer
+	if err := doDecodeRaw(bytes.NewReader(data), &out, true); err != nil {
+		t.Fatal(err)
+	}
+	expected := `1: 5  # offset=0 len=2 int=5 zigzag=-3 uint=5 hex=0x5
+2: 1.000000  # offset=2 len=5 float=1 int=1065353216 uint=1065353216 hex=0x3f800000
+3: "\001\226\001"  # offset=7 len=5 packed=[1, 150]
+4: <  # offset=12 len=4
+  1: 1  # offset=14 len=2 int=1 zigzag=-1 uint=1 hex=0x1
+>
+`
+	if out.String() != expected {
+		t.Errorf("wrong output:\nexpected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
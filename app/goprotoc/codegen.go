@@ -10,19 +10,28 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 
 	"github.com/jhump/protoreflect/desc"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
 
 	"github.com/jhump/goprotoc/plugins"
 )
 
+// protocVersionStruct is the version sent to plugins. Since protoc 22.0,
+// the major version sent to plugins is that of the C++ runtime, which is
+// different from the protoc version (protocVersionEmu).
 var protocVersionStruct = plugins.ProtocVersion{
-	Major:  3,
-	Minor:  5,
+	Major:  5,
+	Minor:  27,
 	Patch:  1,
 	Suffix: "go",
 }
@@ -70,31 +79,102 @@ func (f outputFile) String() string {
 	return fmt.Sprintf("%s:%s", f.loc.path, f.fileName)
 }
 
-func doCodeGen(outputs map[string]string, fds []*desc.FileDescriptor, pluginDefs map[string]string) error {
+// codeGenOptions control how plugins are run and how their outputs are written.
+type codeGenOptions struct {
+	// pluginDefs maps output names to the locations of plugin programs,
+	// from --plugin arguments.
+	pluginDefs           map[string]string
+	experimentalEditions bool
+	// jobs is the maximum number of plugins to run concurrently. If zero,
+	// the number of CPUs is used.
+	jobs int
+	// pluginTimeout, if not zero, is how long each plugin may run before it
+	// is killed.
+	pluginTimeout time.Duration
+	// pluginTimeouts overrides pluginTimeout for particular outputs, keyed
+	// by output name.
+	pluginTimeouts map[string]time.Duration
+	// check, if true, causes generated outputs to be compared with the
+	// files on disk instead of written.
+	check bool
+	// diff is like check, but also prints a unified diff of any outputs
+	// that are out of date.
+	diff bool
+	// removeStale, if true, causes files that were generated by a previous
+	// run, but not by this one, to be removed from output directories. The
+	// files generated by each output are recorded in a manifest file in the
+	// output directory.
+	removeStale bool
+	// summary, if true, causes a summary of the files created, updated, and
+	// removed to be printed.
+	summary bool
+	// cacheDir, if not empty, is a directory in which the responses of plugin
+	// programs are cached, so they need not be run again for the same inputs.
+	cacheDir string
+	// cacheMaxSize is the limit on the total size of the cache's entries. If
+	// zero, defaultCacheMaxSize is used.
+	cacheMaxSize int64
+	// noCache, if true, causes the cache to be bypassed: it is neither read
+	// nor written.
+	noCache bool
+	// stderr is where the standard error output of plugin programs is
+	// written. If nil, os.Stderr is used.
+	stderr io.Writer
+}
+
+// doCodeGen runs the plugins for the given outputs and writes the generated
+// files. It returns the sorted paths of all files (and archives) generated,
+// including those that were not written because they were unchanged. If
+// opts.summary is set, a summary of the changes is written to w.
+//
+// If opts.check or opts.diff is set, nothing is written. Instead, the outputs
+// are compared with the files on disk and an error is returned if any are out
+// of date. Diffs are written to w.
+func doCodeGen(ctx context.Context, outputs map[string]string, fds []*desc.FileDescriptor, opts *codeGenOptions, w io.Writer) ([]string, error) {
 	locations, args, err := computeOutputLocations(outputs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	resps, err := runPlugins(args, fds, pluginDefs)
+	resps, err := runPlugins(ctx, args, fds, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	results, err := assembleFileOutputs(resps, locations)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	var created map[string][]string
+	var stale []string
+	if opts.removeStale {
+		if created, err = createdFiles(resps, locations); err != nil {
+			return nil, err
+		}
+		if stale, err = findStaleOutputs(created, locations, results); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.check || opts.diff {
+		return nil, checkOutputs(results, stale, opts.diff, w)
 	}
 
 	// now we can accumulate outputs by archive and emit the
 	// normal files
+	var written []string
+	statuses := map[string]writeStatus{}
 	archiveResults := map[outputLocation]map[string]io.Reader{}
 	for file, data := range results {
 		if file.loc.locationType == outputTypeDir {
 			fileName := filepath.Join(file.loc.path, file.fileName)
-			if err := writeFileResult(fileName, data); err != nil {
-				return err
+			status, err := writeFileResult(fileName, data)
+			if err != nil {
+				return nil, err
 			}
+			statuses[fileName] = status
+			written = append(written, fileName)
 		} else {
 			archiveFiles := archiveResults[file.loc]
 			if archiveFiles == nil {
@@ -107,12 +187,60 @@ func doCodeGen(outputs map[string]string, fds []*desc.FileDescriptor, pluginDefs
 
 	// finally: emit any archives
 	for location, files := range archiveResults {
-		if err := writeArchiveResult(location.path, location.locationType == outputTypeJar, files); err != nil {
-			return err
+		status, err := writeArchiveResult(location.path, location.locationType == outputTypeJar, files)
+		if err != nil {
+			return nil, err
 		}
+		statuses[location.path] = status
+		written = append(written, location.path)
 	}
 
-	return nil
+	if opts.removeStale {
+		if err := removeStaleOutputs(stale, locations); err != nil {
+			return nil, err
+		}
+		if err := updateManifests(created, locations); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(written)
+	if opts.summary {
+		if err := printCodeGenSummary(w, written, statuses, stale); err != nil {
+			return nil, err
+		}
+	}
+	return written, nil
+}
+
+// printCodeGenSummary prints each file that was created, updated, or removed,
+// followed by the number of files in each category.
+func printCodeGenSummary(w io.Writer, written []string, statuses map[string]writeStatus, removed []string) error {
+	var numCreated, numUpdated, numUnchanged int
+	for _, fileName := range written {
+		var action string
+		switch statuses[fileName] {
+		case writeCreated:
+			numCreated++
+			action = "created"
+		case writeUpdated:
+			numUpdated++
+			action = "updated"
+		default:
+			numUnchanged++
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", action, displayPath(fileName)); err != nil {
+			return err
+		}
+	}
+	for _, fileName := range removed {
+		if _, err := fmt.Fprintf(w, "removed %s\n", displayPath(fileName)); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d created, %d updated, %d unchanged, %d removed\n", numCreated, numUpdated, numUnchanged, len(removed))
+	return err
 }
 
 func computeOutputLocations(outputs map[string]string) (map[string]outputLocation, map[string]string, error) {
@@ -171,22 +299,152 @@ func computeOutputLocations(outputs map[string]string) (map[string]outputLocatio
 	return locations, args, nil
 }
 
-func runPlugins(args map[string]string, fds []*desc.FileDescriptor, pluginDefs map[string]string) (map[string]*plugins.CodeGenResponse, error) {
-	req := plugins.CodeGenRequest{
-		Files:         fds,
-		ProtocVersion: protocVersionStruct,
+// runPlugins runs the plugins for the given outputs, at most opts.jobs at a
+// time. If opts.jobs is zero, the number of CPUs is used. If any plugins fail, the
+// returned error has the failures of all plugins, sorted by output name, so
+// that the error does not depend on which plugin finished first.
+func runPlugins(ctx context.Context, args map[string]string, fds []*desc.FileDescriptor, opts *codeGenOptions) (map[string]*plugins.CodeGenResponse, error) {
+	jobs := opts.jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
 	}
-	resps := map[string]*plugins.CodeGenResponse{}
+	langs := make([]string, 0, len(args))
+	for lang := range args {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
 
-	for lang, arg := range args {
-		resp := plugins.NewCodeGenResponse(lang, nil)
-		resps[lang] = resp
-		pluginName := pluginDefs[lang]
-		if err := executePlugin(&req, resp, pluginName, lang, arg); err != nil {
+	var cache *codeGenCache
+	if opts.cacheDir != "" && !opts.noCache {
+		var err error
+		if cache, err = newCodeGenCache(opts.cacheDir, opts.cacheMaxSize); err != nil {
 			return nil, err
 		}
 	}
-	return resps, nil
+
+	execOpts := &plugins.ExecOptions{Stderr: opts.stderr}
+
+	resps := make([]*plugins.CodeGenResponse, len(langs))
+	errs := make([]error, len(langs))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, lang := range langs {
+		resps[i] = plugins.NewCodeGenResponse(lang, nil)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, lang string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			// each plugin gets its own request since the args are different
+			req := plugins.CodeGenRequest{
+				Files:         fds,
+				ProtocVersion: protocVersionStruct,
+			}
+			timeout := opts.pluginTimeout
+			if t, ok := opts.pluginTimeouts[lang]; ok {
+				timeout = t
+			}
+			pluginCtx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				pluginCtx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			if err := executePlugin(pluginCtx, &req, resps[i], opts.pluginDefs[lang], lang, args[lang], execOpts, cache); err != nil {
+				if pluginCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+					err = fmt.Errorf("plugin timed out after %v", timeout)
+				} else if ctx.Err() != nil {
+					err = fmt.Errorf("plugin was cancelled: %v", ctx.Err())
+				}
+				errs[i] = fmt.Errorf("--%s_out: %w", lang, err)
+				return
+			}
+			if err := checkFeatures(lang, resps[i], fds, opts.experimentalEditions); err != nil {
+				errs[i] = fmt.Errorf("--%s_out: %w", lang, err)
+			}
+		}(i, lang)
+	}
+	wg.Wait()
+
+	var failures []error
+	for _, err := range errs {
+		if err != nil {
+			failures = append(failures, err)
+		}
+	}
+	if err := toError(failures); err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		if err := cache.prune(); err != nil {
+			return nil, fmt.Errorf("failed to prune cache: %v", err)
+		}
+	}
+	results := make(map[string]*plugins.CodeGenResponse, len(langs))
+	for i, lang := range langs {
+		results[lang] = resps[i]
+	}
+	return results, nil
+}
+
+// checkFeatures verifies that the plugin that produced the given response
+// supports the features used by the given files. Unless experimentalEditions
+// is true, this also verifies that the plugin supports the editions used by
+// the given files.
+func checkFeatures(lang string, resp *plugins.CodeGenResponse, fds []*desc.FileDescriptor, experimentalEditions bool) error {
+	if !resp.IsFeatureSupported(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL) {
+		for _, fd := range fds {
+			if containsProto3Optional(fd.GetMessageTypes()) {
+				return fmt.Errorf("%s: is a proto3 file that contains optional fields, but code generator %s hasn't been updated to support optional fields in proto3. Please ask the owner of this code generator to support proto3 optional", fd.GetName(), lang)
+			}
+		}
+	}
+
+	if experimentalEditions {
+		// user has explicitly opted in to editions, regardless of what the
+		// plugin says it supports
+		return nil
+	}
+	minEdition, maxEdition := resp.SupportedEditions()
+	for _, fd := range fds {
+		edition := fd.Edition()
+		if edition == 0 || strings.HasPrefix(fd.GetName(), "google/protobuf/") {
+			// not an editions file or is a standard import, which is exempt
+			continue
+		}
+		if !resp.IsFeatureSupported(pluginpb.CodeGeneratorResponse_FEATURE_SUPPORTS_EDITIONS) {
+			return fmt.Errorf("%s: is an editions file, but code generator %s hasn't been updated to support editions yet. Please ask the owner of this code generator to add support or switch back to proto2/proto3", fd.GetName(), lang)
+		}
+		if edition < minEdition {
+			return fmt.Errorf("%s: is a file using edition %s, which isn't supported by code generator %s. Please upgrade your file to at least edition %s", fd.GetName(), editionString(edition), lang, editionString(minEdition))
+		}
+		if edition > maxEdition {
+			return fmt.Errorf("%s: is a file using edition %s, which isn't supported by code generator %s. Please ask the owner of this code generator to add support or switch back to a maximum of edition %s", fd.GetName(), editionString(edition), lang, editionString(maxEdition))
+		}
+	}
+	return nil
+}
+
+// editionString returns the given edition as it would appear in an edition
+// declaration in a proto source file, such as "2023".
+func editionString(edition descriptorpb.Edition) string {
+	return strings.TrimPrefix(edition.String(), "EDITION_")
+}
+
+func containsProto3Optional(mds []*desc.MessageDescriptor) bool {
+	for _, md := range mds {
+		for _, fld := range md.GetFields() {
+			if fld.IsProto3Optional() {
+				return true
+			}
+		}
+		if containsProto3Optional(md.GetNestedMessageTypes()) {
+			return true
+		}
+	}
+	return false
 }
 
 func assembleFileOutputs(resps map[string]*plugins.CodeGenResponse, locations map[string]outputLocation) (map[outputFile]io.Reader, error) {
@@ -240,25 +498,87 @@ func assembleFileOutputs(resps map[string]*plugins.CodeGenResponse, locations ma
 	return resultData, nil
 }
 
-func writeFileResult(fileName string, data io.Reader) (e error) {
+// writeStatus describes the effect of writing a generated file.
+type writeStatus int
+
+const (
+	writeCreated writeStatus = iota
+	writeUpdated
+	writeUnchanged
+)
+
+// writeFileResult writes the given data to the given file. If the file already
+// has exactly the given contents, it is left untouched, so that its
+// modification time does not change. Otherwise, the data is written to a
+// temporary file which is then renamed, so that the file is replaced
+// atomically.
+func writeFileResult(fileName string, data io.Reader) (writeStatus, error) {
+	contents, err := io.ReadAll(data)
+	if err != nil {
+		return 0, err
+	}
+	existing, err := os.ReadFile(fileName)
+	status := writeUpdated
+	switch {
+	case os.IsNotExist(err):
+		status = writeCreated
+	case err != nil:
+		return 0, err
+	case bytes.Equal(existing, contents):
+		return writeUnchanged, nil
+	}
+
 	// we've already checked that the output directory exists, but the generated
 	// file could be nested inside directories therein, which we want to auto-create
 	if err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm); err != nil {
-		return err
+		return 0, err
 	}
-	w, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		return err
+	if err := writeFileAtomically(fileName, contents); err != nil {
+		return 0, err
+	}
+	return status, nil
+}
+
+// tempFileCounter is used to generate unique names for temporary files.
+var tempFileCounter uint64
+
+// writeFileAtomically writes the given contents to a temporary file in the
+// same directory as fileName and then renames it to fileName. If fileName
+// already exists, its permissions are preserved.
+func writeFileAtomically(fileName string, contents []byte) (e error) {
+	var f *os.File
+	var tmpName string
+	for {
+		tmpName = filepath.Join(filepath.Dir(fileName), fmt.Sprintf(".%s.%d-%d.tmp", filepath.Base(fileName), os.Getpid(), atomic.AddUint64(&tempFileCounter, 1)))
+		var err error
+		f, err = os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
 	}
 	defer func() {
-		closeErr := w.Close()
-		if closeErr != nil && e == nil {
-			e = closeErr
+		if e != nil {
+			_ = os.Remove(tmpName)
 		}
 	}()
 
-	_, err = io.Copy(w, data)
-	return err
+	_, err := f.Write(contents)
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if info, err := os.Stat(fileName); err == nil {
+		if err := os.Chmod(tmpName, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpName, fileName)
 }
 
 // same manifest that protoc produces, except "goprotoc" instead of "protoc"
@@ -268,23 +588,12 @@ Created-By: 1.6.0 (goprotoc)
 
 `)
 
-func writeArchiveResult(fileName string, includeManifest bool, files map[string]io.Reader) (e error) {
-	fw, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		return err
-	}
-	z := zip.NewWriter(fw)
-
-	defer func() {
-		closeErr := z.Close()
-		if closeErr != nil && e == nil {
-			e = closeErr
-		}
-		closeErr = fw.Close()
-		if closeErr != nil && e == nil {
-			e = closeErr
-		}
-	}()
+func writeArchiveResult(fileName string, includeManifest bool, files map[string]io.Reader) (writeStatus, error) {
+	// Build the archive in memory. The entries have no timestamps, so the
+	// archive contents are deterministic, which lets us skip writing it if
+	// it is unchanged.
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
 
 	if includeManifest {
 		mw, err := z.CreateHeader(&zip.FileHeader{
@@ -292,10 +601,10 @@ func writeArchiveResult(fileName string, includeManifest bool, files map[string]
 			Method: zip.Store,
 		})
 		if err != nil {
-			return err
+			return 0, err
 		}
 		if _, err := mw.Write(manifestContents); err != nil {
-			return err
+			return 0, err
 		}
 	}
 
@@ -311,14 +620,17 @@ func writeArchiveResult(fileName string, includeManifest bool, files map[string]
 			Method: zip.Store,
 		})
 		if err != nil {
-			return err
+			return 0, err
 		}
 		if _, err = io.Copy(w, files[name]); err != nil {
-			return err
+			return 0, err
 		}
 	}
+	if err := z.Close(); err != nil {
+		return 0, err
+	}
 
-	return nil
+	return writeFileResult(fileName, &buf)
 }
 
 type fileOutput struct {
@@ -338,44 +650,129 @@ type fileOutput struct {
 // If function will panic if the given name already has an in-process plugin
 // registered.
 //
-// This function is not thread-safe. It should be invoked during program
-// initialization, before other functions in this package are invoked to run
-// the goprotoc tool.
+// This function is safe to call concurrently, but it should usually be invoked
+// during program initialization, before other functions in this package are
+// invoked to run the goprotoc tool. Since goprotoc runs plugins concurrently,
+// the given plugin may be invoked at the same time as other plugins, so it must
+// not rely on unsynchronized global state. If the plugin runs for longer than
+// the --plugin_timeout, goprotoc reports that it timed out, but the plugin can't
+// be stopped, so it keeps running until it returns or the program exits.
 func RegisterPlugin(lang string, plugin plugins.Plugin) {
+	inprocessPluginsMu.Lock()
+	defer inprocessPluginsMu.Unlock()
 	if _, ok := inprocessPlugins[lang]; ok {
 		panic(fmt.Sprintf("plugin already registered for %q", lang))
 	}
 	inprocessPlugins[lang] = plugin
 }
 
-var inprocessPlugins = map[string]plugins.Plugin{}
+// unregisterPlugin removes the in-process plugin registered for the given
+// name, if any. It is used by tests, which must not leave plugins registered.
+func unregisterPlugin(lang string) {
+	inprocessPluginsMu.Lock()
+	defer inprocessPluginsMu.Unlock()
+	delete(inprocessPlugins, lang)
+}
+
+var (
+	inprocessPlugins   = map[string]plugins.Plugin{}
+	inprocessPluginsMu sync.RWMutex
+)
 
-func executePlugin(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse, pluginName, lang, outputArg string) error {
+func getInprocessPlugin(lang string) (plugins.Plugin, bool) {
+	inprocessPluginsMu.RLock()
+	defer inprocessPluginsMu.RUnlock()
+	p, ok := inprocessPlugins[lang]
+	return p, ok
+}
+
+func executePlugin(ctx context.Context, req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse, pluginName, lang, outputArg string, execOpts *plugins.ExecOptions, cache *codeGenCache) error {
 	if len(outputArg) > 0 {
 		req.Args = strings.Split(outputArg, ",")
 	}
 	if pluginName == "" {
 		// no configured plugin path, so first check if we have an in-process plugin
-		if p, ok := inprocessPlugins[lang]; ok {
-			return p(req, resp)
+		if p, ok := getInprocessPlugin(lang); ok {
+			return runInprocessPlugin(ctx, p, req, resp)
 		}
 		// maybe it's an output provided by protoc
 		if _, ok := protocOutputs[lang]; ok {
-			return driveProtocAsPlugin(req, resp, lang)
+			return driveProtocAsPlugin(ctx, req, resp, lang)
 		}
 		// otherwise, assume plugin program name by convention
 		pluginName = "protoc-gen-" + lang
 	}
-	return plugins.Exec(context.Background(), pluginName, req, resp)
+	return execPlugin(ctx, pluginName, req, resp, execOpts, cache)
 }
 
-func driveProtocAsPlugin(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse, lang string) (err error) {
+// runInprocessPlugin runs the given in-process plugin. Unlike a plugin program,
+// it can't be killed, so if the given context is done first, this returns the
+// context's error without waiting for the plugin, which is left to finish in
+// the background.
+func runInprocessPlugin(ctx context.Context, p plugins.Plugin, req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error {
+	if ctx.Done() == nil {
+		// can never be cancelled, so no need for another goroutine
+		return p(req, resp)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- p(req, resp)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// execPlugin runs the given plugin program. If cache is not nil and has a
+// response for the given plugin and request, the cached response is used
+// instead of running the plugin. Otherwise, the plugin's response is added
+// to the cache.
+func execPlugin(ctx context.Context, pluginName string, req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse, execOpts *plugins.ExecOptions, cache *codeGenCache) error {
+	if cache == nil {
+		return plugins.ExecWithOptions(ctx, pluginName, req, resp, execOpts)
+	}
+	key, err := cache.key(pluginName, req.AsCodeGeneratorRequest())
+	if err != nil {
+		// Can't compute the key, which means we likely can't run the plugin
+		// either. So run it without the cache, to report the usual error.
+		return plugins.ExecWithOptions(ctx, pluginName, req, resp, execOpts)
+	}
+	if respb, ok := cache.get(key); ok {
+		return resp.AddCodeGeneratorResponse(respb)
+	}
+
+	// Collect the outputs in a separate response, since converting it to a
+	// message to cache consumes its contents.
+	tmpResp := plugins.NewCodeGenResponse(pluginName, nil)
+	if err := plugins.ExecWithOptions(ctx, pluginName, req, tmpResp, execOpts); err != nil {
+		return err
+	}
+	respb, err := tmpResp.AsCodeGeneratorResponse()
+	if err != nil {
+		return err
+	}
+	if err := cache.put(key, respb); err != nil {
+		return fmt.Errorf("failed to write to cache: %v", err)
+	}
+	return resp.AddCodeGeneratorResponse(respb)
+}
+
+func driveProtocAsPlugin(ctx context.Context, req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse, lang string) (err error) {
 	for _, arg := range req.Args {
 		if strings.HasPrefix(arg, "-") {
 			return fmt.Errorf("option %q for %s output does not start with '-'", arg, lang)
 		}
 	}
 
+	// protoc's builtin generators all support proto3 optional and editions,
+	// and protoc itself will complain if the given files use features or
+	// editions that it doesn't support
+	resp.SupportsFeatures(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	resp.SupportsEditions(descriptorpb.Edition_EDITION_PROTO2, descriptorpb.Edition_EDITION_MAX)
+
 	tmpDir, err := os.MkdirTemp("", "go-protoc")
 	if err != nil {
 		return err
@@ -417,7 +814,7 @@ func driveProtocAsPlugin(req *plugins.CodeGenRequest, resp *plugins.CodeGenRespo
 		args = append(args, name)
 	}
 
-	cmd := exec.Command("protoc", args...)
+	cmd := exec.CommandContext(ctx, "protoc", args...)
 	var combinedOutput bytes.Buffer
 	cmd.Stdout = &combinedOutput
 	cmd.Stderr = &combinedOutput
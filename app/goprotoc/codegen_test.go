@@ -0,0 +1,371 @@
+package goprotoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhump/goprotoc/plugins"
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// registerTestPlugin registers the given in-process plugin for the duration of
+// the test.
+func registerTestPlugin(t *testing.T, lang string, plugin plugins.Plugin) {
+	t.Helper()
+	RegisterPlugin(lang, plugin)
+	t.Cleanup(func() {
+		unregisterPlugin(lang)
+	})
+}
+
+func TestRunPlugins_Concurrent(t *testing.T) {
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto": `syntax = "proto3"; message Foo {}`,
+	}, "test.proto")
+
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("test_ok_%d", i)
+		registerTestPlugin(t, name, func(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error {
+			_, err := fmt.Fprintf(resp.OutputFile(name+".txt"), "%v", req.Args)
+			return err
+		})
+	}
+	registerTestPlugin(t, "test_fail_a", func(*plugins.CodeGenRequest, *plugins.CodeGenResponse) error {
+		return errors.New("boom a")
+	})
+	registerTestPlugin(t, "test_fail_b", func(*plugins.CodeGenRequest, *plugins.CodeGenResponse) error {
+		return errors.New("boom b")
+	})
+
+	args := map[string]string{}
+	for i := 0; i < 4; i++ {
+		args[fmt.Sprintf("test_ok_%d", i)] = fmt.Sprintf("arg%d", i)
+	}
+	resps, err := runPlugins(context.Background(), args, fds, &codeGenOptions{jobs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("test_ok_%d", i)
+		var contents string
+		err := resps[name].ForEach(func(_, _ string, data io.Reader) error {
+			_, err := fmt.Fscan(data, &contents)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("[arg%d]", i); contents != expected {
+			t.Errorf("%s: expected output %q, got %q", name, expected, contents)
+		}
+	}
+
+	args["test_fail_b"] = ""
+	args["test_fail_a"] = ""
+	_, err = runPlugins(context.Background(), args, fds, &codeGenOptions{})
+	if expected := "--test_fail_a_out: boom a\n--test_fail_b_out: boom b"; err == nil || err.Error() != expected {
+		t.Errorf("wrong error: expected %q, got %v", expected, err)
+	}
+}
+
+func TestDoCodeGen_Check(t *testing.T) {
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto": `syntax = "proto3"; message Foo {}`,
+	}, "test.proto")
+	registerTestPlugin(t, "test_check", func(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error {
+		for _, arg := range req.Args {
+			if _, err := fmt.Fprintf(resp.OutputFile(arg+".txt"), "contents of %s\n", arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	dir := t.TempDir()
+	outputs := map[string]string{"test_check": "a,b:" + dir}
+	if _, err := doCodeGen(context.Background(), outputs, fds, &codeGenOptions{}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doCodeGen(context.Background(), outputs, fds, &codeGenOptions{check: true}, io.Discard); err != nil {
+		t.Errorf("expecting outputs to be up to date, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("contents of A\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	outputs["test_check"] = "a,b,c:" + dir
+	var diff bytes.Buffer
+	_, err := doCodeGen(context.Background(), outputs, fds, &codeGenOptions{diff: true}, &diff)
+	expectedErr := filepath.Join(dir, "a.txt") + ": is out of date\n" + filepath.Join(dir, "c.txt") + ": is missing"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("wrong error: expected %q, got %v", expectedErr, err)
+	}
+	expectedDiff := "--- " + filepath.Join(dir, "a.txt") + "\n+++ " + filepath.Join(dir, "a.txt") + "\n" +
+		"@@ -1 +1 @@\n-contents of A\n+contents of a\n" +
+		"--- /dev/null\n+++ " + filepath.Join(dir, "c.txt") + "\n" +
+		"@@ -0,0 +1 @@\n+contents of c\n"
+	if diff.String() != expectedDiff {
+		t.Errorf("wrong diff:\nexpected:\n%s\ngot:\n%s", expectedDiff, diff.String())
+	}
+	// check mode must not change anything on disk
+	if _, err := os.Stat(filepath.Join(dir, "c.txt")); !os.IsNotExist(err) {
+		t.Errorf("check mode should not have written c.txt")
+	}
+}
+
+func TestDoCodeGen_RemoveStale(t *testing.T) {
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto": `syntax = "proto3"; message Foo {}`,
+	}, "test.proto")
+	registerTestPlugin(t, "test_stale", func(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error {
+		for _, arg := range req.Args {
+			if _, err := fmt.Fprintf(resp.OutputFile(arg+"/gen.txt"), "contents of %s\n", arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	dir := t.TempDir()
+	// a file that goprotoc did not generate must never be removed
+	if err := os.WriteFile(filepath.Join(dir, "other.txt"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	opts := &codeGenOptions{removeStale: true}
+	if _, err := doCodeGen(context.Background(), map[string]string{"test_stale": "a,b:" + dir}, fds, opts, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	outputs := map[string]string{"test_stale": "b,c:" + dir}
+	checkOpts := &codeGenOptions{removeStale: true, check: true}
+	_, err := doCodeGen(context.Background(), outputs, fds, checkOpts, io.Discard)
+	expectedErr := filepath.Join(dir, "a", "gen.txt") + ": should not exist\n" + filepath.Join(dir, "c", "gen.txt") + ": is missing"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("wrong error: expected %q, got %v", expectedErr, err)
+	}
+	if _, err := doCodeGen(context.Background(), outputs, fds, opts, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", filepath.Join("a", "gen.txt")} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("stale %s should have been removed", name)
+		}
+	}
+	for _, name := range []string{"other.txt", filepath.Join("b", "gen.txt"), filepath.Join("c", "gen.txt")} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s should not have been removed: %v", name, err)
+		}
+	}
+	manifest, err := readManifest(manifestPath(dir, "test_stale"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"b/gen.txt", "c/gen.txt"}; !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("wrong manifest: expected %v, got %v", expected, manifest)
+	}
+}
+
+func TestWriteFileResult(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "sub", "foo.txt")
+	write := func(contents string, expected writeStatus) {
+		t.Helper()
+		status, err := writeFileResult(fileName, strings.NewReader(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != expected {
+			t.Errorf("wrong status: expected %v, got %v", expected, status)
+		}
+		actual, err := os.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != contents {
+			t.Errorf("wrong contents: expected %q, got %q", contents, actual)
+		}
+	}
+	write("abc", writeCreated)
+
+	// set an old mtime so we can verify that unchanged files are not touched
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(fileName, old, old); err != nil {
+		t.Fatal(err)
+	}
+	write("abc", writeUnchanged)
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Errorf("unchanged file should not have been modified")
+	}
+
+	write("def", writeUpdated)
+	entries, err := os.ReadDir(filepath.Dir(fileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files should not be left behind: %v", entries)
+	}
+
+	archive := filepath.Join(dir, "foo.jar")
+	for _, expected := range []writeStatus{writeCreated, writeUnchanged} {
+		status, err := writeArchiveResult(archive, true, map[string]io.Reader{"a.txt": strings.NewReader("abc")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != expected {
+			t.Errorf("wrong status for archive: expected %v, got %v", expected, status)
+		}
+	}
+}
+
+func TestRunPlugins_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script as a plugin")
+	}
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto": `syntax = "proto3"; message Foo {}`,
+	}, "test.proto")
+
+	// this plugin starts a child process and then hangs
+	pluginPath := filepath.Join(t.TempDir(), "protoc-gen-hang")
+	if err := os.WriteFile(pluginPath, []byte("#!/bin/sh\nsleep 60 &\nsleep 60\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	opts := &codeGenOptions{
+		pluginDefs:    map[string]string{"hang": pluginPath},
+		pluginTimeout: 200 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err := runPlugins(context.Background(), map[string]string{"hang": ""}, fds, opts)
+	if expected := "--hang_out: plugin timed out after 200ms"; err == nil || err.Error() != expected {
+		t.Errorf("wrong error: expected %q, got %v", expected, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("plugin should have been killed promptly, but took %v", elapsed)
+	}
+}
+
+func TestRunPlugins_TimeoutInprocess(t *testing.T) {
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto": `syntax = "proto3"; message Foo {}`,
+	}, "test.proto")
+
+	// this plugin hangs until the test is done
+	release := make(chan struct{})
+	defer close(release)
+	registerTestPlugin(t, "test_hang", func(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error {
+		<-release
+		return nil
+	})
+	opts := &codeGenOptions{pluginTimeout: 200 * time.Millisecond}
+	_, err := runPlugins(context.Background(), map[string]string{"test_hang": ""}, fds, opts)
+	if expected := "--test_hang_out: plugin timed out after 200ms"; err == nil || err.Error() != expected {
+		t.Errorf("wrong error: expected %q, got %v", expected, err)
+	}
+}
+
+func TestCheckFeatures_Proto3Optional(t *testing.T) {
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto": `syntax = "proto3"; message Foo { optional string name = 1; }`,
+	}, "test.proto")
+
+	resp := plugins.NewCodeGenResponse("test", nil)
+	err := checkFeatures("test", resp, fds, false)
+	if err == nil || !strings.Contains(err.Error(), "test.proto: is a proto3 file that contains optional fields, but code generator test hasn't been updated to support optional fields in proto3") {
+		t.Errorf("expected error about proto3 optional, got %v", err)
+	}
+
+	resp.SupportsFeatures(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if err := checkFeatures("test", resp, fds, false); err != nil {
+		t.Errorf("plugin supports proto3 optional, but got error: %v", err)
+	}
+}
+
+func TestCheckFeatures_Editions(t *testing.T) {
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto":                 `edition = "2023"; message Foo { string name = 1; }`,
+		"google/protobuf/test.proto": `edition = "2023"; package google.protobuf; message Bar {}`,
+		"proto3.proto":               `syntax = "proto3"; message Baz {}`,
+	}, "test.proto", "google/protobuf/test.proto", "proto3.proto")
+	wellKnown, proto3 := fds[1:2], fds[2:]
+
+	testCases := []struct {
+		name                 string
+		fds                  []*desc.FileDescriptor
+		minEdition           descriptorpb.Edition
+		maxEdition           descriptorpb.Edition
+		experimentalEditions bool
+		expectedErr          string
+	}{
+		{
+			name:        "no editions support",
+			fds:         fds,
+			expectedErr: "test.proto: is an editions file, but code generator test hasn't been updated to support editions yet",
+		},
+		{
+			name:       "supported edition",
+			fds:        fds,
+			minEdition: descriptorpb.Edition_EDITION_PROTO2,
+			maxEdition: descriptorpb.Edition_EDITION_2023,
+		},
+		{
+			name:        "below minimum",
+			fds:         fds,
+			minEdition:  descriptorpb.Edition_EDITION_2024,
+			maxEdition:  descriptorpb.Edition_EDITION_2024,
+			expectedErr: "test.proto: is a file using edition 2023, which isn't supported by code generator test. Please upgrade your file to at least edition 2024",
+		},
+		{
+			name:        "above maximum",
+			fds:         fds,
+			minEdition:  descriptorpb.Edition_EDITION_PROTO2,
+			maxEdition:  descriptorpb.Edition_EDITION_PROTO3,
+			expectedErr: "test.proto: is a file using edition 2023, which isn't supported by code generator test. Please ask the owner of this code generator to add support or switch back to a maximum of edition PROTO3",
+		},
+		{
+			name: "standard import is exempt",
+			fds:  wellKnown,
+		},
+		{
+			name: "not an editions file",
+			fds:  proto3,
+		},
+		{
+			name:                 "experimental editions",
+			fds:                  fds,
+			experimentalEditions: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := plugins.NewCodeGenResponse("test", nil)
+			if tc.maxEdition != 0 {
+				resp.SupportsEditions(tc.minEdition, tc.maxEdition)
+			}
+			err := checkFeatures("test", resp, tc.fds, tc.experimentalEditions)
+			if tc.expectedErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("expected error containing %q, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package goprotoc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxDelimitedRecordSize is the largest length prefix we will accept in a
+// stream of length-delimited messages. Messages cannot be 2GB or larger, so
+// anything larger than this indicates a corrupt stream.
+const maxDelimitedRecordSize = math.MaxInt32
+
+// offsetReader tracks the number of bytes consumed from a buffered reader,
+// so that errors can report the location of the bad record.
+type offsetReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *offsetReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.offset++
+	}
+	return b, err
+}
+
+func (r *offsetReader) ReadBytes(delim byte) ([]byte, error) {
+	b, err := r.r.ReadBytes(delim)
+	r.offset += int64(len(b))
+	return b, err
+}
+
+// decodeDelimited reads a stream of length-delimited binary messages from r
+// and writes each one, in the decoded format, to w. Each message in the input
+// is preceded by its length, encoded as a varint.
+func (c *messageCodec) decodeDelimited(r io.Reader, w io.Writer) error {
+	if c.opts.getEncodedFormat() != messageFormatBinary {
+		return errors.New("--delimited can only be used when the encoded format is binary")
+	}
+	format := c.opts.getDecodedFormat()
+	in := &offsetReader{r: bufio.NewReader(r)}
+	for i := 0; ; i++ {
+		start := in.offset
+		size, err := binary.ReadUvarint(in)
+		if err == io.EOF {
+			// clean EOF: no more records
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read length of record %d at offset %d: %v", i, start, err)
+		}
+		if size > maxDelimitedRecordSize {
+			return fmt.Errorf("failed to read record %d at offset %d: length %d is too large", i, start, size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(in, data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("failed to read record %d at offset %d: %v", i, start, err)
+		}
+		dm := c.newMessage()
+		if err := c.unmarshal(dm, data, messageFormatBinary); err != nil {
+			return fmt.Errorf("failed to parse record %d at offset %d: %v", i, start, err)
+		}
+		b, err := c.marshal(dm, format)
+		if err != nil {
+			return fmt.Errorf("failed to format record %d: %v", i, err)
+		}
+		if format == messageFormatText && i > 0 {
+			// blank line separates text records
+			b = append([]byte{'\n'}, b...)
+		}
+		if _, err := w.Write(b); err != nil {
+			return fmt.Errorf("failed to write decoded message: %v", err)
+		}
+	}
+}
+
+// encodeDelimited reads a stream of messages in the decoded format from r and
+// writes each one to w in binary format, preceded by its length encoded as a
+// varint. Messages in text format must be separated by blank lines. Messages
+// in JSON format are simply concatenated (optionally separated by whitespace).
+func (c *messageCodec) encodeDelimited(r io.Reader, w io.Writer) error {
+	if c.opts.getEncodedFormat() != messageFormatBinary {
+		return errors.New("--delimited can only be used when the encoded format is binary")
+	}
+	format := c.opts.getDecodedFormat()
+	in := &offsetReader{r: bufio.NewReader(r)}
+	var next func() ([]byte, int64, error)
+	switch format {
+	case messageFormatText:
+		next = func() ([]byte, int64, error) {
+			return readTextRecord(in)
+		}
+	case messageFormatJSON:
+		dec := json.NewDecoder(in.r)
+		next = func() ([]byte, int64, error) {
+			start := dec.InputOffset()
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, start, err
+			}
+			return raw, start, nil
+		}
+	default:
+		return errors.New("--delimited cannot be used to encode messages that are already in binary format")
+	}
+
+	for i := 0; ; i++ {
+		data, start, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read record %d at offset %d: %v", i, start, err)
+		}
+		dm := c.newMessage()
+		if err := c.unmarshal(dm, data, format); err != nil {
+			return fmt.Errorf("failed to parse record %d at offset %d: %v", i, start, err)
+		}
+		b, err := dm.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to serialize record %d: %v", i, err)
+		}
+		b = append(protowire.AppendVarint(nil, uint64(len(b))), b...)
+		if _, err := w.Write(b); err != nil {
+			return fmt.Errorf("failed to write encoded message: %v", err)
+		}
+	}
+}
+
+// readTextRecord reads lines from in until it reaches a blank line or EOF.
+// Leading blank lines are skipped. It returns the record and its offset. If
+// there are no more records, it returns io.EOF.
+func readTextRecord(in *offsetReader) ([]byte, int64, error) {
+	var record []byte
+	start := in.offset
+	for {
+		line, err := in.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, start, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			if len(record) > 0 {
+				return record, start, nil
+			}
+			if err == io.EOF {
+				return nil, start, io.EOF
+			}
+			// still skipping leading blank lines
+			start = in.offset
+			continue
+		}
+		record = append(record, line...)
+		if err == io.EOF {
+			return record, start, nil
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package goprotoc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+//lint:file-ignore ST1005 capitalized errors that are sentences are command return values printed to stderr
+
+// writeDependencyFile writes a make-style dependency file to dest. The given
+// outputs are the targets and the transitive closure of the given files are
+// the prerequisites. Like protoc, the prerequisites are paths on disk, so they
+// are resolved against the given import paths.
+func writeDependencyFile(dest string, outputs []string, fds []*desc.FileDescriptor, importPaths []string, fromDescriptors bool) error {
+	var allFiles []*desc.FileDescriptor
+	seen := map[string]struct{}{}
+	for _, fd := range fds {
+		allFiles = addTransitiveDependencies(fd, seen, allFiles)
+	}
+
+	if len(importPaths) == 0 {
+		importPaths = []string{"."}
+	}
+	diskFiles := make([]string, 0, len(allFiles))
+	for _, fd := range allFiles {
+		if fromDescriptors {
+			// descriptors did not come from source, so there is nothing on
+			// disk that we can refer to
+			return fmt.Errorf("Unable to identify path for file %s", fd.GetName())
+		}
+		diskFile, ok := findOnDisk(fd.GetName(), importPaths)
+		if !ok {
+			if strings.HasPrefix(fd.GetName(), "google/protobuf/") {
+				// standard imports are built into the parser so may not
+				// actually exist on disk
+				continue
+			}
+			return fmt.Errorf("Unable to identify path for file %s", fd.GetName())
+		}
+		diskFiles = append(diskFiles, diskFile)
+	}
+
+	var buf bytes.Buffer
+	for i, out := range outputs {
+		buf.WriteString(escapeMakePath(out))
+		if i < len(outputs)-1 {
+			buf.WriteString(" \\\n")
+		}
+	}
+	buf.WriteRune(':')
+	for i, f := range diskFiles {
+		buf.WriteRune(' ')
+		buf.WriteString(escapeMakePath(f))
+		if i < len(diskFiles)-1 {
+			buf.WriteString("\\\n")
+		}
+	}
+	buf.WriteRune('\n')
+	return os.WriteFile(dest, buf.Bytes(), 0666)
+}
+
+func addTransitiveDependencies(fd *desc.FileDescriptor, seen map[string]struct{}, files []*desc.FileDescriptor) []*desc.FileDescriptor {
+	if _, ok := seen[fd.GetName()]; ok {
+		return files
+	}
+	seen[fd.GetName()] = struct{}{}
+	for _, dep := range fd.GetDependencies() {
+		files = addTransitiveDependencies(dep, seen, files)
+	}
+	return append(files, fd)
+}
+
+func findOnDisk(fileName string, importPaths []string) (string, bool) {
+	for _, importPath := range importPaths {
+		diskFile := filepath.Join(importPath, fileName)
+		if info, err := os.Stat(diskFile); err == nil && info.Mode().IsRegular() {
+			return diskFile, true
+		}
+	}
+	return "", false
+}
+
+// escapeMakePath escapes the given path so it can be used as a target or
+// prerequisite in a make rule.
+func escapeMakePath(path string) string {
+	var buf bytes.Buffer
+	for _, r := range path {
+		switch r {
+		case ' ', '\t', '#':
+			buf.WriteRune('\\')
+		case '$':
+			buf.WriteRune('$')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
@@ -0,0 +1,61 @@
+package goprotoc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+func TestEscapeMakePath(t *testing.T) {
+	testCases := []struct {
+		path, expected string
+	}{
+		{path: "foo/bar.pb.go", expected: "foo/bar.pb.go"},
+		{path: "foo bar/baz.pb.go", expected: `foo\ bar/baz.pb.go`},
+		{path: "foo#1/$bar.pb.go", expected: `foo\#1/$$bar.pb.go`},
+	}
+	for _, tc := range testCases {
+		if actual := escapeMakePath(tc.path); actual != tc.expected {
+			t.Errorf("escapeMakePath(%q): expected %q, got %q", tc.path, tc.expected, actual)
+		}
+	}
+}
+
+func TestWriteDependencyFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, contents string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("a.proto", `syntax = "proto3"; import "b.proto"; import "google/protobuf/empty.proto"; message A { B b = 1; }`)
+	writeFile("b.proto", `syntax = "proto3"; message B { }`)
+
+	p := protoparse.Parser{ImportPaths: []string{dir}}
+	fds, err := p.ParseFiles("a.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "deps.d")
+	outputs := []string{"out/a.pb.go", "out/b.pb.go"}
+	if err := writeDependencyFile(dest, outputs, fds, []string{dir}, false); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "out/a.pb.go \\\nout/b.pb.go: " +
+		filepath.Join(dir, "b.proto") + "\\\n " +
+		filepath.Join(dir, "a.proto") + "\n"
+	if string(contents) != expected {
+		t.Errorf("wrong dependency file contents:\nexpected:\n%s\ngot:\n%s", expected, contents)
+	}
+
+	if err := writeDependencyFile(dest, outputs, fds, []string{dir}, true); err == nil {
+		t.Error("expecting error when descriptors did not come from source")
+	}
+}
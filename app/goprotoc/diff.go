@@ -0,0 +1,266 @@
+package goprotoc
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change
+// in a unified diff.
+const diffContextLines = 3
+
+type diffOpKind int
+
+const (
+	diffEqual diffOpKind = iota
+	diffDelete
+	diffInsert
+)
+
+// diffOp is a single line in an edit script. For deletions, only aIndex is
+// meaningful; for insertions, only bIndex.
+type diffOp struct {
+	kind           diffOpKind
+	aIndex, bIndex int
+}
+
+// unifiedDiff returns a unified diff that transforms a into b. The given names
+// are used in the diff's header. If a and b are the same, it returns the empty
+// string.
+func unifiedDiff(nameA, nameB string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	linesA, linesB := splitLines(a), splitLines(b)
+	ops := diffLines(linesA, linesB)
+
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "--- %s\n+++ %s\n", nameA, nameB)
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == diffEqual {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// extend the hunk until there is a long enough run of equal lines
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != diffEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == diffEqual {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContextLines {
+				break
+			}
+			end = run
+		}
+		hunkStart := start - diffContextLines
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := end + diffContextLines
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+		writeHunk(&buf, ops[hunkStart:hunkEnd], linesA, linesB)
+		start = end
+	}
+	return buf.String()
+}
+
+func writeHunk(buf *bytes.Buffer, ops []diffOp, linesA, linesB []string) {
+	var startA, startB, countA, countB int
+	startA, startB = -1, -1
+	for _, op := range ops {
+		if op.kind != diffInsert {
+			if startA == -1 {
+				startA = op.aIndex
+			}
+			countA++
+		}
+		if op.kind != diffDelete {
+			if startB == -1 {
+				startB = op.bIndex
+			}
+			countB++
+		}
+	}
+	_, _ = fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(startA, countA, ops, true), hunkRange(startB, countB, ops, false))
+	for _, op := range ops {
+		var prefix byte
+		var line string
+		switch op.kind {
+		case diffEqual:
+			prefix, line = ' ', linesA[op.aIndex]
+		case diffDelete:
+			prefix, line = '-', linesA[op.aIndex]
+		case diffInsert:
+			prefix, line = '+', linesB[op.bIndex]
+		}
+		_ = buf.WriteByte(prefix)
+		_, _ = buf.WriteString(line)
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			_, _ = buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the range of lines in one side of a hunk. When the hunk
+// contains no lines from that side, the start is the line before the hunk.
+func hunkRange(start, count int, ops []diffOp, sideA bool) string {
+	if count == 0 {
+		// no lines on this side, so use the position where they would be
+		op := ops[0]
+		if sideA {
+			start = op.aIndex
+		} else {
+			start = op.bIndex
+		}
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits data into lines, each of which includes its trailing
+// newline (except possibly the last line).
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// diffLines computes a minimal edit script that transforms a into b, using
+// the linear space variant of Myers' O(ND) difference algorithm.
+func diffLines(a, b []string) []diffOp {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+	// the furthest reaching paths only need to cover half of the edits
+	offset := (len(a)+len(b)+1)/2 + 1
+	d := differ{
+		a:        a,
+		b:        b,
+		forward:  make([]int, 2*offset+1),
+		backward: make([]int, 2*offset+1),
+		offset:   offset,
+		ops:      make([]diffOp, 0, len(a)+len(b)),
+	}
+	d.diff(0, len(a), 0, len(b))
+	return d.ops
+}
+
+// differ holds the state for diffLines. The forward and backward slices hold
+// the furthest reaching paths, indexed by diagonal plus offset. They are
+// shared by all of the sub-problems, which are solved one at a time.
+type differ struct {
+	a, b              []string
+	forward, backward []int
+	offset            int
+	ops               []diffOp
+}
+
+// diff appends the edit script that transforms a[aLo:aHi] into b[bLo:bHi].
+func (d *differ) diff(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.ops = append(d.ops, diffOp{kind: diffEqual, aIndex: aLo, bIndex: bLo})
+		aLo++
+		bLo++
+	}
+	suffixA := aHi
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for y := bLo; y < bHi; y++ {
+			d.ops = append(d.ops, diffOp{kind: diffInsert, aIndex: aLo, bIndex: y})
+		}
+	case bLo == bHi:
+		for x := aLo; x < aHi; x++ {
+			d.ops = append(d.ops, diffOp{kind: diffDelete, aIndex: x, bIndex: bLo})
+		}
+	default:
+		// Both sides are non-empty and differ at both ends, so there are at
+		// least two edits and the halves around the middle snake are both
+		// smaller problems.
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.diff(aLo, x, bLo, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.ops = append(d.ops, diffOp{kind: diffEqual, aIndex: x, bIndex: y})
+		}
+		d.diff(u, aHi, v, bHi)
+	}
+
+	for ; aHi < suffixA; aHi, bHi = aHi+1, bHi+1 {
+		d.ops = append(d.ops, diffOp{kind: diffEqual, aIndex: aHi, bIndex: bHi})
+	}
+}
+
+// middleSnake finds the middle snake of an optimal edit script that transforms
+// a[aLo:aHi] into b[bLo:bHi], by searching forward from the start and backward
+// from the end until the paths overlap. It returns the snake's start (x, y) and
+// end (u, v).
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (int, int, int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	// Forward paths are indexed by diagonal k = x - y. Backward paths are
+	// measured from the end, so the backward diagonal for k is delta - k.
+	fwd, bwd, off := d.forward, d.backward, d.offset
+	fwd[off+1], bwd[off+1] = 0, 0
+	for dist := 0; dist <= (n+m+1)/2; dist++ {
+		for k := -dist; k <= dist; k += 2 {
+			var x int
+			if k == -dist || (k != dist && fwd[off+k-1] < fwd[off+k+1]) {
+				x = fwd[off+k+1]
+			} else {
+				x = fwd[off+k-1] + 1
+			}
+			startX := x
+			for x < n && x-k < m && d.a[aLo+x] == d.b[bLo+x-k] {
+				x++
+			}
+			fwd[off+k] = x
+			if back := delta - k; odd && back >= -(dist-1) && back <= dist-1 && x+bwd[off+back] >= n {
+				return aLo + startX, bLo + startX - k, aLo + x, bLo + x - k
+			}
+		}
+		for k := -dist; k <= dist; k += 2 {
+			var x int
+			if k == -dist || (k != dist && bwd[off+k-1] < bwd[off+k+1]) {
+				x = bwd[off+k+1]
+			} else {
+				x = bwd[off+k-1] + 1
+			}
+			startX := x
+			for x < n && x-k < m && d.a[aHi-1-x] == d.b[bHi-1-(x-k)] {
+				x++
+			}
+			bwd[off+k] = x
+			if fore := delta - k; !odd && fore >= -dist && fore <= dist && x+fwd[off+fore] >= n {
+				return aHi - x, bHi - (x - k), aHi - startX, bHi - (startX - k)
+			}
+		}
+	}
+	// not reachable: the paths always overlap once they cover all the edits
+	panic("diff: no middle snake found")
+}
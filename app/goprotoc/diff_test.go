@@ -0,0 +1,133 @@
+package goprotoc
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	testCases := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{
+			name: "equal",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+		},
+		{
+			name:     "new file",
+			a:        "",
+			b:        "a\nb\n",
+			expected: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:     "deleted file",
+			a:        "a\n",
+			b:        "",
+			expected: "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b:    "1\ntwo\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n",
+			expected: "--- old\n+++ new\n" +
+				"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -10,3 +10,4 @@\n 10\n 11\n 12\n+13\n",
+		},
+		{
+			name: "merged hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n",
+			b:    "1\n2\n3\n4\n5\n6\n7\n8",
+			expected: "--- old\n+++ new\n" +
+				"@@ -5,3 +5,4 @@\n 5\n 6\n 7\n+8\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := unifiedDiff("old", "new", []byte(tc.a), []byte(tc.b))
+			if actual != tc.expected {
+				t.Errorf("wrong diff:\nexpected:\n%s\ngot:\n%s", tc.expected, actual)
+			}
+		})
+	}
+
+	// a large diff to make sure the edit script is correct
+	var a, b strings.Builder
+	for i := 0; i < 1000; i++ {
+		if i%7 != 0 {
+			a.WriteString(strings.Repeat("x", i%13) + "\n")
+		}
+		if i%5 != 0 {
+			b.WriteString(strings.Repeat("x", i%13) + "\n")
+		}
+	}
+	ops := diffLines(splitLines([]byte(a.String())), splitLines([]byte(b.String())))
+	var rebuiltA, rebuiltB strings.Builder
+	linesA, linesB := splitLines([]byte(a.String())), splitLines([]byte(b.String()))
+	for _, op := range ops {
+		if op.kind != diffInsert {
+			rebuiltA.WriteString(linesA[op.aIndex])
+		}
+		if op.kind != diffDelete {
+			rebuiltB.WriteString(linesB[op.bIndex])
+		}
+	}
+	if rebuiltA.String() != a.String() || rebuiltB.String() != b.String() {
+		t.Error("edit script does not reproduce inputs")
+	}
+}
+
+func TestDiffLines_Large(t *testing.T) {
+	const numLines = 8000
+	generated := make([]string, numLines)
+	changed := make([]string, numLines)
+	// a complete rewrite is the worst case for time, so it is smaller
+	rewritten := make([]string, numLines/4)
+	for i := range generated {
+		generated[i] = fmt.Sprintf("line %d\n", i)
+		changed[i] = generated[i]
+		if i%10 == 0 {
+			changed[i] = fmt.Sprintf("changed line %d\n", i)
+		}
+	}
+	for i := range rewritten {
+		rewritten[i] = fmt.Sprintf("other line %d\n", i)
+	}
+	testCases := []struct {
+		name          string
+		a, b          []string
+		expectedEdits int
+	}{
+		{name: "missing file", a: nil, b: generated, expectedEdits: numLines},
+		{name: "deleted file", a: generated, b: nil, expectedEdits: numLines},
+		{name: "some changes", a: generated, b: changed, expectedEdits: numLines / 5},
+		{name: "all changed", a: generated, b: rewritten, expectedEdits: numLines + numLines/4},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			ops := diffLines(tc.a, tc.b)
+			runtime.ReadMemStats(&after)
+
+			var edits int
+			for _, op := range ops {
+				if op.kind != diffEqual {
+					edits++
+				}
+			}
+			if edits != tc.expectedEdits {
+				t.Errorf("wrong number of edits: expected %d, got %d", tc.expectedEdits, edits)
+			}
+			// memory must be linear in the size of the inputs: the edit script
+			// plus the furthest reaching paths
+			if allocated, limit := after.TotalAlloc-before.TotalAlloc, uint64(4<<20); allocated > limit {
+				t.Errorf("diff allocated %d bytes, expected no more than %d", allocated, limit)
+			}
+		})
+	}
+}
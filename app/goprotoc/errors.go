@@ -0,0 +1,137 @@
+package goprotoc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+//lint:file-ignore ST1005 capitalized errors that are sentences are command return values printed to stderr
+
+type errorFormat int
+
+const (
+	errorFormatGCC errorFormat = iota
+	errorFormatMSVS
+	errorFormatJSON
+)
+
+func parseErrorFormat(s string) (errorFormat, error) {
+	switch s {
+	case "gcc":
+		return errorFormatGCC, nil
+	case "msvs":
+		return errorFormatMSVS, nil
+	case "json":
+		return errorFormatJSON, nil
+	default:
+		return 0, fmt.Errorf("Unknown error format: %s", s)
+	}
+}
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// diagnostic is an error or warning that is reported to the user. When
+// using JSON error format, diagnostics are emitted as JSON objects, one
+// per line.
+type diagnostic struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func newDiagnostic(err error, severity string) diagnostic {
+	var errWithPos protoparse.ErrorWithPos
+	if errors.As(err, &errWithPos) {
+		pos := errWithPos.GetPosition()
+		msg := err.Error()
+		if cause := errWithPos.Unwrap(); cause != nil {
+			msg = cause.Error()
+		}
+		return diagnostic{
+			File:     pos.Filename,
+			Line:     pos.Line,
+			Column:   pos.Col,
+			Severity: severity,
+			Message:  msg,
+		}
+	}
+	return diagnostic{Severity: severity, Message: err.Error()}
+}
+
+// errorPrinter formats errors and warnings according to the format
+// indicated by the --error_format flag.
+type errorPrinter struct {
+	format      errorFormat
+	importPaths []string
+}
+
+func (p *errorPrinter) formatDiagnostic(d diagnostic) string {
+	if p.format == errorFormatJSON {
+		b, err := json.Marshal(d)
+		if err != nil {
+			// should not be possible: all fields are strings and ints
+			return d.Message
+		}
+		return string(b)
+	}
+
+	var buf strings.Builder
+	file := d.File
+	if p.format == errorFormatMSVS && file != "" {
+		// Visual Studio wants a path it can actually open
+		if diskFile, ok := findOnDisk(file, p.importPaths); ok {
+			file = diskFile
+		}
+	}
+	buf.WriteString(file)
+	if d.Line > 0 {
+		switch p.format {
+		case errorFormatGCC:
+			_, _ = fmt.Fprintf(&buf, ":%d:%d", d.Line, d.Column)
+		case errorFormatMSVS:
+			_, _ = fmt.Fprintf(&buf, "(%d) : %s in column=%d", d.Line, d.Severity, d.Column)
+		}
+	}
+	if file != "" {
+		buf.WriteString(": ")
+	}
+	if d.Severity == severityWarning && p.format == errorFormatGCC {
+		buf.WriteString("warning: ")
+	}
+	buf.WriteString(d.Message)
+	return buf.String()
+}
+
+// formatError returns an error whose message is the given error, formatted
+// according to the configured error format. If the given error is a
+// multiError, each constituent error is formatted on its own line.
+func (p *errorPrinter) formatError(err error) error {
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(multiError)
+	if !ok {
+		errs = multiError{err}
+	}
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = p.formatDiagnostic(newDiagnostic(e, severityError))
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+// printWarning writes the given warning to w, formatted according to the
+// configured error format.
+func (p *errorPrinter) printWarning(w io.Writer, err error) {
+	_, _ = fmt.Fprintln(w, p.formatDiagnostic(newDiagnostic(err, severityWarning)))
+}
@@ -0,0 +1,76 @@
+package goprotoc
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatDiagnostic(t *testing.T) {
+	withPos := diagnostic{File: "foo/bar.proto", Line: 12, Column: 3, Severity: severityError, Message: "syntax error"}
+	warning := diagnostic{File: "foo/bar.proto", Line: 1, Column: 1, Severity: severityWarning, Message: "import not used"}
+	noPos := diagnostic{Severity: severityError, Message: "--go_out: plugin failed"}
+
+	testCases := []struct {
+		format   errorFormat
+		d        diagnostic
+		expected string
+	}{
+		{format: errorFormatGCC, d: withPos, expected: "foo/bar.proto:12:3: syntax error"},
+		{format: errorFormatGCC, d: warning, expected: "foo/bar.proto:1:1: warning: import not used"},
+		{format: errorFormatGCC, d: noPos, expected: "--go_out: plugin failed"},
+		{format: errorFormatMSVS, d: withPos, expected: "foo/bar.proto(12) : error in column=3: syntax error"},
+		{format: errorFormatMSVS, d: warning, expected: "foo/bar.proto(1) : warning in column=1: import not used"},
+		{format: errorFormatMSVS, d: noPos, expected: "--go_out: plugin failed"},
+		{format: errorFormatJSON, d: withPos, expected: `{"file":"foo/bar.proto","line":12,"column":3,"severity":"error","message":"syntax error"}`},
+		{format: errorFormatJSON, d: noPos, expected: `{"severity":"error","message":"--go_out: plugin failed"}`},
+	}
+	for _, tc := range testCases {
+		p := errorPrinter{format: tc.format}
+		if actual := p.formatDiagnostic(tc.d); actual != tc.expected {
+			t.Errorf("wrong formatted output:\nexpected: %s\ngot:      %s", tc.expected, actual)
+		}
+	}
+}
+
+func TestRun_Warnings(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.proto": `syntax = "proto3"; message A {}`,
+		"b.proto": `syntax = "proto3";
+import "a.proto";
+message B {}
+`,
+	})
+	testCases := []struct {
+		errorFormat string
+		expected    string
+	}{
+		{errorFormat: "gcc", expected: `b.proto:2:1: warning: import "a.proto" not used`},
+		{errorFormat: "msvs", expected: filepath.Join(dir, "b.proto") + `(2) : warning in column=1: import "a.proto" not used`},
+		{errorFormat: "json", expected: `{"file":"b.proto","line":2,"column":1,"severity":"warning","message":"import \"a.proto\" not used"}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.errorFormat, func(t *testing.T) {
+			args := []string{"goprotoc", "-I", dir, "--error_format=" + tc.errorFormat, "--descriptor_set_out=" + filepath.Join(t.TempDir(), "out.pb"), "b.proto"}
+			var stdout, stderr bytes.Buffer
+			if code := RunContext(context.Background(), args, nil, &stdout, &stderr); code != 0 {
+				t.Fatalf("warnings should not fail the run, got exit code %d:\n%s", code, stderr.String())
+			}
+			if actual := strings.TrimSpace(stderr.String()); actual != tc.expected {
+				t.Errorf("wrong warning:\nexpected: %s\ngot:      %s", tc.expected, actual)
+			}
+
+			stderr.Reset()
+			args = append(args[:len(args)-1], "--fatal_warnings", "b.proto")
+			if code := RunContext(context.Background(), args, nil, &stdout, &stderr); code == 0 {
+				t.Error("--fatal_warnings should fail the run")
+			}
+			if actual := strings.TrimSpace(stderr.String()); actual != tc.expected {
+				t.Errorf("wrong output with --fatal_warnings:\nexpected: %s\ngot:      %s", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,523 @@
+package goprotoc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/bufbuild/protocompile/ast"
+	"github.com/bufbuild/protocompile/parser"
+	"github.com/bufbuild/protocompile/reporter"
+)
+
+//lint:file-ignore ST1005 capitalized errors that are sentences are command return values printed to stderr
+
+// runFormat implements the format command, which rewrites proto source files
+// in a canonical style. If --check or --diff is given, files are not
+// rewritten. Instead, an error is returned that names every file that is not
+// formatted.
+func runFormat(programName string, args []string, stdout io.Writer, stderr io.Writer) error {
+	var opts protocOptions
+	if err := parseFlags("", programName, args, stdout, &opts, map[string]struct{}{}); err != nil {
+		switch err {
+		case errVersion, errUsage:
+			return nil
+		default:
+			return err
+		}
+	}
+	return formatWithOptions(&opts, stdout, stderr)
+}
+
+func formatWithOptions(opts *protocOptions, stdout io.Writer, stderr io.Writer) (e error) {
+	errPrinter := &errorPrinter{format: opts.errorFormat, importPaths: opts.includePaths}
+	defer func() {
+		if e != errFatalWarnings {
+			e = errPrinter.formatError(e)
+		}
+	}()
+
+	if len(opts.protoFiles) == 0 {
+		return errors.New("Missing input file.")
+	}
+	if usesOutputOptions(opts) {
+		return errors.New("Only --proto_path, --exclude, --check, --diff, --error_format, and --fatal_warnings can be used with format.")
+	}
+
+	fileNames, err := resolveInputs(opts)
+	if err != nil {
+		return err
+	}
+	fds, err := parseSources(fileNames, opts, true, errPrinter, stderr, nil)
+	if err != nil {
+		return err
+	}
+
+	check := opts.codeGen.check || opts.codeGen.diff
+	var errs []error
+	for _, fd := range fds {
+		fileName := fd.GetName()
+		if len(opts.includePaths) > 0 {
+			fileName, _ = findOnDisk(fileName, opts.includePaths)
+		}
+		onDisk, err := os.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		formatted, err := formatProto(fd.GetName(), onDisk)
+		if err != nil {
+			return fmt.Errorf("failed to format %s: %v", fd.GetName(), err)
+		}
+		if bytes.Equal(onDisk, formatted) {
+			continue
+		}
+		if !check {
+			if _, err := writeFileResult(fileName, bytes.NewReader(formatted)); err != nil {
+				return err
+			}
+			continue
+		}
+		if abs, err := filepath.Abs(fileName); err == nil {
+			fileName = displayPath(abs)
+		}
+		errs = append(errs, fmt.Errorf("%s: is not formatted", fileName))
+		if opts.codeGen.diff {
+			if _, err := io.WriteString(stdout, unifiedDiff(fileName, fileName, onDisk, formatted)); err != nil {
+				return err
+			}
+		}
+	}
+	return toError(errs)
+}
+
+// formatProto returns the given source file printed in canonical style. Each
+// declaration is on its own line, indented by two spaces per level, with
+// single spaces between tokens. Imports are sorted by name, which changes only
+// the order of the file's dependencies. Options are sorted without changing
+// their meaning, with standard options before custom ones (see optionLess).
+// All comments are kept, as written, and so are blank lines between
+// declarations, though several blank lines in a row become one. At the top
+// level, a blank line also separates declarations of different kinds and
+// surrounds every message, enum, service, and extend block.
+func formatProto(fileName string, src []byte) ([]byte, error) {
+	file, err := parser.Parse(fileName, bytes.NewReader(src), reporter.NewHandler(nil))
+	if err != nil {
+		return nil, err
+	}
+	f := protoFormatter{file: file, lineStart: true}
+	children := file.Children()
+	f.decls(children[:len(children)-1], true)
+	f.endComments(file.EOF, len(children) > 1)
+	f.newline()
+	return f.buf.Bytes(), nil
+}
+
+// protoFormatter prints the AST of a file. Every token of the file is written
+// along with the comments attributed to it, so no comments are lost.
+type protoFormatter struct {
+	file   *ast.FileNode
+	buf    bytes.Buffer
+	indent int
+	// continued is true if a line comment in the middle of a declaration broke
+	// it over several lines. The rest of the declaration is indented further.
+	continued bool
+	// lineStart is true if nothing has been written to the current line.
+	lineStart bool
+	// prev is the last token written on the current line, or nil if a
+	// comment was written after it.
+	prev ast.TerminalNode
+	// space is true if the next token must be preceded by a space.
+	space bool
+}
+
+func (f *protoFormatter) decls(decls []ast.Node, topLevel bool) {
+	// blank lines stay where they were, even if the elements around them
+	// are sorted
+	blank := make([]bool, len(decls))
+	for i, decl := range decls {
+		blank[i] = f.blankLineBefore(decl.Start())
+	}
+	var prev ast.Node
+	for i, decl := range sortDecls(decls) {
+		if empty, ok := decl.(*ast.EmptyDeclNode); ok && !f.hasComments(empty.Semicolon) {
+			continue
+		}
+		if prev != nil && (blank[i] || topLevel && (declKind(prev) != declKind(decl) || declKind(decl) == "")) {
+			f.blankLine()
+		}
+		f.newline()
+		f.continued = false
+		f.node(decl)
+		f.newline()
+		prev = decl
+	}
+}
+
+func (f *protoFormatter) node(n ast.Node) {
+	switch n := n.(type) {
+	case ast.TerminalNode:
+		f.terminal(n)
+	case *ast.CompactOptionsNode:
+		f.terminal(n.OpenBracket)
+		opts := append([]*ast.OptionNode(nil), n.Options...)
+		sort.SliceStable(opts, func(i, j int) bool {
+			return optionLess(opts[i], opts[j])
+		})
+		for i, opt := range opts {
+			f.node(opt)
+			if i < len(n.Commas) {
+				f.terminal(n.Commas[i])
+			}
+		}
+		f.terminal(n.CloseBracket)
+	case *ast.MessageLiteralNode:
+		f.messageLiteral(n)
+	case ast.CompositeNode:
+		children := n.Children()
+		for i, child := range children {
+			if open, ok := child.(*ast.RuneNode); ok && open.Rune == '{' && isBlock(n) {
+				f.block(open, children[i+1:len(children)-1], children[len(children)-1].(*ast.RuneNode))
+				return
+			}
+			f.node(child)
+		}
+	}
+}
+
+// block writes a body of declarations, enclosed in braces.
+func (f *protoFormatter) block(open *ast.RuneNode, decls []ast.Node, close *ast.RuneNode) {
+	f.terminal(open)
+	if len(decls) == 0 && !f.hasComments(close) && f.file.NodeInfo(open).TrailingComments().Len() == 0 {
+		f.terminal(close)
+		return
+	}
+	f.indent++
+	f.decls(decls, false)
+	f.endComments(close, len(decls) > 0)
+	f.indent--
+	f.newline()
+	f.continued = false
+	f.token(close)
+	f.trailing(close)
+}
+
+// messageLiteral writes the value of an option that is a message. It is
+// written on one line if it was on one line in the source. Otherwise, each
+// field is on its own line.
+func (f *protoFormatter) messageLiteral(n *ast.MessageLiteralNode) {
+	f.terminal(n.Open)
+	openLine := f.file.NodeInfo(n.Open).Start().Line
+	if openLine == f.file.NodeInfo(n.Close).Start().Line || (len(n.Elements) == 0 && !f.hasComments(n.Close)) {
+		for i, elem := range n.Elements {
+			f.node(elem)
+			if n.Seps[i] != nil {
+				f.terminal(n.Seps[i])
+			}
+		}
+		f.terminal(n.Close)
+		return
+	}
+	indent, continued := f.indent, f.continued
+	if continued {
+		f.indent += 2
+	}
+	f.indent++
+	for i, elem := range n.Elements {
+		if i > 0 && f.blankLineBefore(elem.Start()) {
+			f.blankLine()
+		}
+		f.newline()
+		f.continued = false
+		f.node(elem)
+		if n.Seps[i] != nil {
+			f.terminal(n.Seps[i])
+		}
+	}
+	f.endComments(n.Close, len(n.Elements) > 0)
+	f.indent--
+	f.newline()
+	f.continued = false
+	f.token(n.Close)
+	f.trailing(n.Close)
+	f.indent, f.continued = indent, continued
+}
+
+// endComments writes the comments before the given token, which ends a body
+// or the file, each on its own line.
+func (f *protoFormatter) endComments(close ast.TerminalNode, afterDecls bool) {
+	comments := f.file.NodeInfo(close).LeadingComments()
+	if comments.Len() == 0 {
+		return
+	}
+	f.newline()
+	f.continued = false
+	if afterDecls && isBlankLine(comments.Index(0).LeadingWhitespace()) {
+		f.blankLine()
+	}
+	f.lineComments(comments)
+	f.newline()
+}
+
+func (f *protoFormatter) terminal(t ast.TerminalNode) {
+	f.leading(t)
+	f.token(t)
+	f.trailing(t)
+}
+
+func (f *protoFormatter) leading(t ast.TerminalNode) {
+	info := f.file.NodeInfo(t)
+	comments := info.LeadingComments()
+	if comments.Len() == 0 {
+		return
+	}
+	if !f.lineStart {
+		for i := 0; i < comments.Len(); i++ {
+			f.inlineComment(comments.Index(i))
+		}
+		return
+	}
+	f.lineComments(comments)
+	switch ws := info.LeadingWhitespace(); {
+	case isBlankLine(ws):
+		f.blankLine()
+	case strings.Contains(ws, "\n") || isLineComment(comments.Index(comments.Len()-1)):
+		f.newline()
+	default:
+		f.space = true
+	}
+}
+
+func (f *protoFormatter) trailing(t ast.TerminalNode) {
+	info := f.file.NodeInfo(t)
+	comments := info.TrailingComments()
+	line := info.End().Line
+	for i := 0; i < comments.Len(); i++ {
+		c := comments.Index(i)
+		if c.Start().Line == line {
+			f.inlineComment(c)
+		} else {
+			// like protoc, the parser attributes comments on the lines after
+			// an element to it if a blank line follows them
+			if isBlankLine(c.LeadingWhitespace()) {
+				f.blankLine()
+			}
+			f.newline()
+			f.write(commentText(c))
+			f.newline()
+			f.continued = true
+		}
+		line = c.End().Line
+	}
+}
+
+// lineComments writes the given comments, each at the start of its own line.
+// Blank lines between them are kept.
+func (f *protoFormatter) lineComments(comments ast.Comments) {
+	for i := 0; i < comments.Len(); i++ {
+		c := comments.Index(i)
+		if i > 0 && isBlankLine(c.LeadingWhitespace()) {
+			f.blankLine()
+		}
+		f.newline()
+		f.write(commentText(c))
+		f.prev = nil
+	}
+}
+
+// inlineComment writes a comment after other text on the current line.
+func (f *protoFormatter) inlineComment(c ast.Comment) {
+	if !f.lineStart {
+		f.buf.WriteByte(' ')
+	}
+	f.write(commentText(c))
+	f.prev = nil
+	if isLineComment(c) {
+		f.newline()
+		f.continued = true
+	} else {
+		f.space = true
+	}
+}
+
+func (f *protoFormatter) token(t ast.TerminalNode) {
+	if !f.lineStart && (f.space || needsSpace(f.prev, t)) {
+		f.buf.WriteByte(' ')
+	}
+	f.write(f.file.NodeInfo(t).RawText())
+	f.prev = t
+	f.space = false
+}
+
+func (f *protoFormatter) write(s string) {
+	if f.lineStart {
+		level := f.indent
+		if f.continued {
+			level += 2
+		}
+		f.buf.WriteString(strings.Repeat("  ", level))
+		f.lineStart = false
+	}
+	f.buf.WriteString(s)
+}
+
+func (f *protoFormatter) newline() {
+	if !f.lineStart {
+		f.buf.WriteByte('\n')
+		f.lineStart = true
+	}
+	f.prev = nil
+	f.space = false
+}
+
+func (f *protoFormatter) blankLine() {
+	f.newline()
+	if f.buf.Len() > 0 && !bytes.HasSuffix(f.buf.Bytes(), []byte("\n\n")) {
+		f.buf.WriteByte('\n')
+	}
+}
+
+func (f *protoFormatter) hasComments(t ast.TerminalNode) bool {
+	return f.file.NodeInfo(t).LeadingComments().Len() > 0
+}
+
+// blankLineBefore returns true if the given token, or the first comment
+// before it, is preceded by a blank line.
+func (f *protoFormatter) blankLineBefore(t ast.Token) bool {
+	info := f.file.TokenInfo(t)
+	if comments := info.LeadingComments(); comments.Len() > 0 {
+		return isBlankLine(comments.Index(0).LeadingWhitespace())
+	}
+	return isBlankLine(info.LeadingWhitespace())
+}
+
+// needsSpace returns true if a space should separate the given tokens.
+func needsSpace(prev, cur ast.TerminalNode) bool {
+	if prev == nil {
+		return true
+	}
+	switch runeOf(prev) {
+	case '(', '[', '<', '.', '/', '-', '+':
+		return false
+	}
+	_, prevIsIdent := prev.(*ast.IdentNode)
+	switch runeOf(cur) {
+	case ';', ',', ')', ']', '>', ':', '/':
+		return false
+	case '<':
+		// no space in a map type, after the map keyword
+		_, prevIsKeyword := prev.(*ast.KeywordNode)
+		return !prevIsKeyword
+	case '(':
+		// no space between a method's name and its input type
+		return !prevIsIdent
+	case '.':
+		// a leading dot in a fully-qualified name follows a space
+		return !prevIsIdent && runeOf(prev) != ')'
+	case '}':
+		return runeOf(prev) != '{'
+	}
+	return true
+}
+
+func runeOf(n ast.TerminalNode) rune {
+	if r, ok := n.(*ast.RuneNode); ok {
+		return r.Rune
+	}
+	return 0
+}
+
+// isBlock returns true if the given node has a body of declarations.
+func isBlock(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.MessageNode, *ast.GroupNode, *ast.OneofNode, *ast.EnumNode,
+		*ast.ExtendNode, *ast.ServiceNode, *ast.RPCNode:
+		return true
+	default:
+		return false
+	}
+}
+
+// declKind returns the kind of the given declaration, for the purposes of
+// sorting and separating declarations. Definitions, such as messages and
+// fields, have no kind.
+func declKind(n ast.Node) string {
+	switch n.(type) {
+	case *ast.SyntaxNode, *ast.EditionNode:
+		return "syntax"
+	case *ast.PackageNode:
+		return "package"
+	case *ast.ImportNode:
+		return "import"
+	case *ast.OptionNode:
+		return "option"
+	case *ast.EmptyDeclNode:
+		return "empty"
+	default:
+		return ""
+	}
+}
+
+// sortDecls returns the given declarations with each run of consecutive
+// imports sorted by name and each run of consecutive options sorted by the
+// field that they set.
+func sortDecls(decls []ast.Node) []ast.Node {
+	sorted := append([]ast.Node(nil), decls...)
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && declKind(sorted[end]) == declKind(sorted[start]) {
+			end++
+		}
+		run := sorted[start:end]
+		switch declKind(run[0]) {
+		case "import":
+			sort.SliceStable(run, func(i, j int) bool {
+				return run[i].(*ast.ImportNode).Name.AsString() < run[j].(*ast.ImportNode).Name.AsString()
+			})
+		case "option":
+			sort.SliceStable(run, func(i, j int) bool {
+				return optionLess(run[i].(*ast.OptionNode), run[j].(*ast.OptionNode))
+			})
+		}
+		start = end
+	}
+	return sorted
+}
+
+// optionLess orders options by the field that they set, with standard options
+// before custom ones. Only the first part of each option's name is compared,
+// since that is the field of the options message that the option sets. Custom
+// options are compared by the simple name of the extension, because the same
+// extension can be referred to by names that are qualified differently. So
+// options that may set the same field are never reordered, which could change
+// the values of repeated fields.
+func optionLess(a, b *ast.OptionNode) bool {
+	customA, customB := a.Name.Parts[0].IsExtension(), b.Name.Parts[0].IsExtension()
+	if customA != customB {
+		return customB
+	}
+	return optionField(a) < optionField(b)
+}
+
+// optionField returns the simple name of the field that the given option sets.
+func optionField(opt *ast.OptionNode) string {
+	name := string(opt.Name.Parts[0].Name.AsIdentifier())
+	return name[strings.LastIndexByte(name, '.')+1:]
+}
+
+func commentText(c ast.Comment) string {
+	return strings.TrimRightFunc(c.RawText(), unicode.IsSpace)
+}
+
+func isLineComment(c ast.Comment) bool {
+	return strings.HasPrefix(c.RawText(), "//")
+}
+
+func isBlankLine(whitespace string) bool {
+	return strings.Count(whitespace, "\n") > 1
+}
@@ -0,0 +1,266 @@
+package goprotoc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var formatTestFiles = map[string]string{
+	"a.proto": `syntax = "proto3"; package test; message A {}`,
+	"z.proto": `
+		syntax = "proto3";
+		package z;
+		import "google/protobuf/descriptor.proto";
+		extend google.protobuf.FileOptions { bool opt = 50000; }
+		extend google.protobuf.MessageOptions { int32 msg_opt = 50000; }
+		extend google.protobuf.FieldOptions { string field_opt = 50000; }
+		`,
+	"test.proto": `// Package comment.
+syntax="proto3";
+package test;
+
+// Imports.
+import "z.proto";
+import public "a.proto";
+
+option java_package="com.example";
+option (z.opt)=true;
+option go_package = "example.com/test";
+/* Foo is a message. */
+message Foo {
+      option (z.msg_opt) = 1;
+  option deprecated=true;
+  string name=1 [(z.field_opt)="x", deprecated=true]; // the name
+    A a = 2;
+  enum Kind { KIND_UNSPECIFIED = 0; }
+
+
+  map < string, Kind > kinds = 3;
+
+  // dangling comment at the end of Foo
+}
+service Svc { rpc Do ( Foo ) returns ( stream Foo ); }
+
+// trailing file comment
+`,
+}
+
+const formattedTestProto = `// Package comment.
+syntax = "proto3";
+
+package test;
+
+import public "a.proto";
+// Imports.
+import "z.proto";
+
+option go_package = "example.com/test";
+option java_package = "com.example";
+option (z.opt) = true;
+
+/* Foo is a message. */
+message Foo {
+  option deprecated = true;
+  option (z.msg_opt) = 1;
+  string name = 1 [deprecated = true, (z.field_opt) = "x"]; // the name
+  A a = 2;
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+  }
+
+  map<string, Kind> kinds = 3;
+
+  // dangling comment at the end of Foo
+}
+
+service Svc {
+  rpc Do(Foo) returns (stream Foo);
+}
+
+// trailing file comment
+`
+
+func TestFormatProto(t *testing.T) {
+	src := []byte(formatTestFiles["test.proto"])
+	for i := 0; i < 2; i++ {
+		formatted, err := formatProto("test.proto", src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(formatted) != formattedTestProto {
+			t.Fatalf("wrong output:\n%s", unifiedDiff("expected", "actual", []byte(formattedTestProto), formatted))
+		}
+		// formatting the output again must not change it
+		src = formatted
+	}
+}
+
+func TestFormatProto_Comments(t *testing.T) {
+	src := `syntax = "proto2";
+/* block comment
+   over two lines */
+option (foo) = {
+  name: "foo" // the name
+  /* inline */ tags: [1, 2]
+  nested <x: -1>
+};
+message Foo {
+  optional int32 a = 1 // before the semicolon
+  ;
+  oneof choice { string b = 2;
+    // end of oneof
+  }
+  message Empty {}
+  ;
+}
+/* end of file */
+`
+	expected := `syntax = "proto2";
+
+/* block comment
+   over two lines */
+option (foo) = {
+  name: "foo" // the name
+  /* inline */ tags: [1, 2]
+  nested <x: -1>
+};
+
+message Foo {
+  optional int32 a = 1 // before the semicolon
+      ;
+  oneof choice {
+    string b = 2;
+    // end of oneof
+  }
+  message Empty {}
+}
+/* end of file */
+`
+	formatted, err := formatProto("test.proto", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(formatted) != expected {
+		t.Fatalf("wrong output:\n%s", unifiedDiff("expected", "actual", []byte(expected), formatted))
+	}
+	again, err := formatProto("test.proto", formatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != expected {
+		t.Errorf("formatting is not idempotent:\n%s", unifiedDiff("expected", "actual", []byte(expected), again))
+	}
+}
+
+func TestFormatProto_SameDescriptor(t *testing.T) {
+	options := `syntax = "proto2";
+package p;
+import "google/protobuf/descriptor.proto";
+extend google.protobuf.FileOptions {
+  repeated string step = 50000;
+  repeated string another = 50001;
+}
+extend google.protobuf.FieldOptions { repeated string field_step = 50000; }
+message Steps { repeated string names = 1; }
+extend google.protobuf.MessageOptions { optional Steps steps = 50000; }
+option (step) = "first";
+option (another) = "x";
+option (p.step) = "second";
+option java_package = "com.example";
+option (.p.step) = "third";
+message Foo {
+  option (steps).names = "a";
+  option (p.steps).names = "b";
+  option deprecated = true;
+  optional string name = 1 [(p.field_step) = "a", deprecated = true, (field_step) = "b"];
+}
+`
+	for name, src := range map[string]string{"options.proto": options, "test.proto": formatTestFiles["test.proto"]} {
+		t.Run(name, func(t *testing.T) {
+			formatted, err := formatProto(name, []byte(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := map[string]string{"options.proto": options}
+			for k, v := range formatTestFiles {
+				files[k] = v
+			}
+			before := parseTestFiles(t, files, name)[0].AsFileDescriptorProto()
+			files[name] = string(formatted)
+			after := parseTestFiles(t, files, name)[0].AsFileDescriptorProto()
+			// sorting imports is the only intended change
+			sortDependencies(before)
+			sortDependencies(after)
+			if !proto.Equal(before, after) {
+				t.Errorf("formatting changed the descriptor:\nbefore: %v\nafter: %v", before, after)
+			}
+		})
+	}
+}
+
+// sortDependencies sorts the dependencies of the given file by name, updating
+// the indexes of its public and weak dependencies to match.
+func sortDependencies(fd *descriptorpb.FileDescriptorProto) {
+	deps := append([]string(nil), fd.Dependency...)
+	sort.Strings(fd.Dependency)
+	newIndex := func(i int32) int32 {
+		return int32(sort.SearchStrings(fd.Dependency, deps[i]))
+	}
+	for i, dep := range fd.PublicDependency {
+		fd.PublicDependency[i] = newIndex(dep)
+	}
+	for i, dep := range fd.WeakDependency {
+		fd.WeakDependency[i] = newIndex(dep)
+	}
+}
+
+func TestRunFormat(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, formatTestFiles)
+	testFile := filepath.Join(dir, "test.proto")
+	formatArgs := func(args ...string) []string {
+		return append([]string{"goprotoc", "format", "-I", dir}, append(args, testFile)...)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), formatArgs("--check"), nil, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "test.proto: is not formatted") {
+		t.Fatalf("expected check to fail, got %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("--check should not print a diff:\n%s", stdout.String())
+	}
+	err = run(context.Background(), formatArgs("--diff"), nil, &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected diff to fail")
+	}
+	if !strings.Contains(stdout.String(), "\n-option java_package=\"com.example\";\n") || !strings.Contains(stdout.String(), "\n+import public \"a.proto\";\n") {
+		t.Errorf("wrong diff:\n%s", stdout.String())
+	}
+	if b, err := os.ReadFile(testFile); err != nil || string(b) != formatTestFiles["test.proto"] {
+		t.Fatalf("file should not be changed by --check or --diff (err=%v)", err)
+	}
+
+	if err := run(context.Background(), formatArgs(), nil, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(testFile); err != nil || string(b) != formattedTestProto {
+		t.Fatalf("file was not formatted (err=%v):\n%s", err, b)
+	}
+	if err := run(context.Background(), formatArgs("--check"), nil, &stdout, &stderr); err != nil {
+		t.Errorf("formatted file should pass check: %v", err)
+	}
+
+	err = run(context.Background(), formatArgs("--cpp_out=."), nil, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "can be used with format") {
+		t.Errorf("expected error about unsupported options, got %v", err)
+	}
+}
@@ -1,46 +1,170 @@
 package goprotoc
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 
 	"github.com/jhump/protoreflect/desc"
+	"gopkg.in/yaml.v2"
 )
 
-func doPrintFreeFieldNumbers(fds []*desc.FileDescriptor, w io.Writer) error {
-	for _, fd := range fds {
-		for _, md := range fd.GetMessageTypes() {
-			if err := printMessageFreeFields(md, w); err != nil {
+// freeFieldsFormat is a format for the output of --print_free_field_numbers.
+type freeFieldsFormat int
+
+const (
+	freeFieldsFormatText freeFieldsFormat = iota
+	freeFieldsFormatJSON
+	freeFieldsFormatYAML
+)
+
+func parseFreeFieldsFormat(s string) (freeFieldsFormat, error) {
+	switch s {
+	case "text":
+		return freeFieldsFormatText, nil
+	case "json":
+		return freeFieldsFormatJSON, nil
+	case "yaml":
+		return freeFieldsFormatYAML, nil
+	default:
+		return 0, fmt.Errorf("unknown free field numbers format %q: must be 'text', 'json', or 'yaml'", s)
+	}
+}
+
+// freeFieldsOptions control what --print_free_field_numbers prints.
+type freeFieldsOptions struct {
+	format freeFieldsFormat
+	// messages are the fully-qualified names of the messages to print. If
+	// empty, all messages in the given files are printed.
+	messages []string
+	// next, if positive, is how many of the lowest free field numbers to print
+	// for each message, instead of all free ranges.
+	next int
+}
+
+func (o *freeFieldsOptions) isSet() bool {
+	return o.format != freeFieldsFormatText || len(o.messages) > 0 || o.next > 0
+}
+
+// messageFreeFields is the free field numbers of one message, as printed in
+// the json and yaml formats. Only one of Free and Next is present.
+type messageFreeFields struct {
+	Message string      `json:"message" yaml:"message"`
+	Free    []freeRange `json:"free,omitempty" yaml:"free,omitempty"`
+	Next    []int32     `json:"next,omitempty" yaml:"next,omitempty"`
+}
+
+// freeRange is a range of free field numbers. Both ends are inclusive.
+type freeRange struct {
+	Start int32 `json:"start" yaml:"start"`
+	End   int32 `json:"end" yaml:"end"`
+}
+
+func doPrintFreeFieldNumbers(fds []*desc.FileDescriptor, opts *freeFieldsOptions, w io.Writer) error {
+	var mds []*desc.MessageDescriptor
+	if len(opts.messages) == 0 {
+		for _, fd := range fds {
+			for _, md := range fd.GetMessageTypes() {
+				mds = addMessageAndNested(mds, md)
+			}
+		}
+	} else {
+		for _, name := range opts.messages {
+			md := findMessage(fds, strings.TrimPrefix(name, "."))
+			if md == nil {
+				return fmt.Errorf("type not defined: %s", name)
+			}
+			mds = append(mds, md)
+		}
+	}
+
+	results := make([]messageFreeFields, 0, len(mds))
+	for _, md := range mds {
+		unused := computeFreeRanges(md)
+		result := messageFreeFields{Message: md.GetFullyQualifiedName()}
+		if opts.next > 0 {
+			result.Next = nextFreeNumbers(unused, opts.next)
+		} else {
+			for _, r := range unused {
+				result.Free = append(result.Free, freeRange{Start: r.start, End: r.end})
+			}
+		}
+		results = append(results, result)
+	}
+
+	switch opts.format {
+	case freeFieldsFormatJSON:
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case freeFieldsFormatYAML:
+		data, err := yaml.Marshal(results)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	default:
+		for _, result := range results {
+			if err := printMessageFreeFields(result, w); err != nil {
 				return err
 			}
 		}
+		return nil
 	}
-	return nil
 }
 
-func printMessageFreeFields(md *desc.MessageDescriptor, w io.Writer) error {
+// addMessageAndNested adds the given message to mds, after its nested
+// messages.
+func addMessageAndNested(mds []*desc.MessageDescriptor, md *desc.MessageDescriptor) []*desc.MessageDescriptor {
 	for _, nested := range md.GetNestedMessageTypes() {
-		if err := printMessageFreeFields(nested, w); err != nil {
+		mds = addMessageAndNested(mds, nested)
+	}
+	return append(mds, md)
+}
+
+func findMessage(fds []*desc.FileDescriptor, name string) *desc.MessageDescriptor {
+	for _, fd := range fds {
+		if md := fd.FindMessage(name); md != nil {
+			return md
+		}
+	}
+	return nil
+}
+
+func printMessageFreeFields(result messageFreeFields, w io.Writer) error {
+	if result.Next != nil {
+		if _, err := fmt.Fprintf(w, "%- 35s next:", result.Message); err != nil {
 			return err
 		}
+		for _, n := range result.Next {
+			if _, err := fmt.Fprintf(w, " %d", n); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintln(w)
+		return err
 	}
 
-	unused := computeFreeRanges(md)
-	if _, err := fmt.Fprintf(w, "%- 35s free:", md.GetFullyQualifiedName()); err != nil {
+	if _, err := fmt.Fprintf(w, "%- 35s free:", result.Message); err != nil {
 		return err
 	}
-	for _, r := range unused {
-		if r.end == maxTag {
-			if _, err := fmt.Fprintf(w, " %d-INF", r.start); err != nil {
+	for _, r := range result.Free {
+		if r.End == maxTag {
+			if _, err := fmt.Fprintf(w, " %d-INF", r.Start); err != nil {
 				return err
 			}
-		} else if r.start == r.end {
-			if _, err := fmt.Fprintf(w, " %d", r.start); err != nil {
+		} else if r.Start == r.End {
+			if _, err := fmt.Fprintf(w, " %d", r.Start); err != nil {
 				return err
 			}
 		} else {
-			if _, err := fmt.Fprintf(w, " %d-%d", r.start, r.end); err != nil {
+			if _, err := fmt.Fprintf(w, " %d-%d", r.Start, r.End); err != nil {
 				return err
 			}
 		}
@@ -49,8 +173,38 @@ func printMessageFreeFields(md *desc.MessageDescriptor, w io.Writer) error {
 	return err
 }
 
+// nextFreeNumbers returns the n lowest field numbers in the given free ranges,
+// which must be sorted. Fewer are returned if the ranges do not contain n
+// numbers.
+func nextFreeNumbers(unused []tagRange, n int) []int32 {
+	free := 0
+	for _, r := range unused {
+		free += int(r.end-r.start) + 1
+		if free >= n {
+			break
+		}
+	}
+	if free < n {
+		n = free
+	}
+	next := make([]int32, 0, n)
+	for _, r := range unused {
+		for tag := r.start; tag <= r.end; tag++ {
+			if len(next) == n {
+				return next
+			}
+			next = append(next, tag)
+		}
+	}
+	return next
+}
+
+// computeFreeRanges returns the ranges of field numbers that are not used by
+// any field, reserved range, or extension range of the given message, sorted
+// in ascending order. Field numbers that are reserved for the protobuf
+// implementation are never free.
 func computeFreeRanges(md *desc.MessageDescriptor) []tagRange {
-	var used []tagRange
+	used := []tagRange{{start: specialReservedStart, end: specialReservedEnd}}
 	// compute all used ranges
 	for _, fd := range md.GetFields() {
 		used = append(used, tagRange{start: fd.GetNumber(), end: fd.GetNumber()})
@@ -69,12 +223,12 @@ func computeFreeRanges(md *desc.MessageDescriptor) []tagRange {
 	unused := make([]tagRange, 0, len(used)+1)
 	last := int32(0)
 	for _, r := range used {
-		if r.start <= last+1 {
+		if r.start > last+1 {
+			unused = append(unused, tagRange{start: last + 1, end: r.start - 1})
+		}
+		if r.end > last {
 			last = r.end
-			continue
 		}
-		unused = append(unused, tagRange{start: last + 1, end: r.start - 1})
-		last = r.end
 	}
 	if last < maxTag {
 		unused = append(unused, tagRange{start: last + 1, end: maxTag})
@@ -0,0 +1,143 @@
+package goprotoc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+var freeFieldsTestFiles = map[string]string{
+	"test.proto": `
+		syntax = "proto2";
+		package test;
+		message Foo {
+		  optional string a = 1;
+		  optional string b = 2;
+		  optional string c = 5;
+		  reserved 3, 10 to 12;
+		  extensions 100 to 199;
+		  message Bar {
+		    optional int32 id = 18999;
+		    optional int32 other = 20001;
+		  }
+		}
+		message Baz {
+		  optional string x = 1;
+		  reserved 2 to 20, 18000 to 20000;
+		}`,
+}
+
+func TestComputeFreeRanges(t *testing.T) {
+	fds := parseTestFiles(t, freeFieldsTestFiles, "test.proto")
+	testCases := map[string][]tagRange{
+		"test.Foo":     {{4, 4}, {6, 9}, {13, 99}, {200, 18999}, {20000, maxTag}},
+		"test.Foo.Bar": {{1, 18998}, {20000, 20000}, {20002, maxTag}},
+		// a reserved range that covers the implementation's reserved numbers
+		"test.Baz": {{21, 17999}, {20001, maxTag}},
+	}
+	for name, expected := range testCases {
+		actual := computeFreeRanges(fds[0].FindMessage(name))
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected free ranges %v, got %v", name, expected, actual)
+		}
+	}
+
+	next := nextFreeNumbers(computeFreeRanges(fds[0].FindMessage("test.Foo")), 6)
+	if expected := []int32{4, 6, 7, 8, 9, 13}; !reflect.DeepEqual(next, expected) {
+		t.Errorf("expected next free numbers %v, got %v", expected, next)
+	}
+	// asking for more numbers than are free returns all of them
+	next = nextFreeNumbers([]tagRange{{3, 4}, {7, 7}}, 2000000000)
+	if expected := []int32{3, 4, 7}; !reflect.DeepEqual(next, expected) {
+		t.Errorf("expected next free numbers %v, got %v", expected, next)
+	}
+}
+
+func TestRun_PrintFreeFieldNumbers(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(freeFieldsTestFiles["test.proto"]), 0666); err != nil {
+		t.Fatal(err)
+	}
+	runFree := func(flags ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		args := append([]string{"goprotoc", "-I", dir, "--print_free_field_numbers"}, flags...)
+		err := run(context.Background(), append(args, "test.proto"), nil, &stdout, &stderr)
+		return stdout.String(), err
+	}
+
+	out, err := runFree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		"test.Foo.Bar                        free: 1-18998 20000 20002-INF\n" +
+		"test.Foo                            free: 4 6-9 13-99 200-18999 20000-INF\n" +
+		"test.Baz                            free: 21-17999 20001-INF\n"
+	if out != expected {
+		t.Errorf("wrong output:\nexpected:\n%s\ngot:\n%s", expected, out)
+	}
+
+	out, err = runFree("--free_field_numbers_message=.test.Foo", "--free_field_numbers_message=test.Baz", "--next_free_field_numbers=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "" +
+		"test.Foo                            next: 4 6 7\n" +
+		"test.Baz                            next: 21 22 23\n"
+	if out != expected {
+		t.Errorf("wrong output:\nexpected:\n%s\ngot:\n%s", expected, out)
+	}
+
+	out, err = runFree("--free_field_numbers_format=json", "--free_field_numbers_message=test.Foo.Bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []messageFreeFields
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, out)
+	}
+	expectedResults := []messageFreeFields{{
+		Message: "test.Foo.Bar",
+		Free:    []freeRange{{1, 18998}, {20000, 20000}, {20002, maxTag}},
+	}}
+	if !reflect.DeepEqual(results, expectedResults) {
+		t.Errorf("wrong JSON output: %s", out)
+	}
+
+	out, err = runFree("--free_field_numbers_format=yaml", "--free_field_numbers_message=test.Baz", "--next_free_field_numbers=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = nil
+	if err := yaml.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("output is not valid YAML: %v\n%s", err, out)
+	}
+	expectedResults = []messageFreeFields{{Message: "test.Baz", Next: []int32{21, 22}}}
+	if !reflect.DeepEqual(results, expectedResults) {
+		t.Errorf("wrong YAML output: %s", out)
+	}
+
+	if _, err := runFree("--free_field_numbers_message=test.Nope"); err == nil || !strings.Contains(err.Error(), "type not defined: test.Nope") {
+		t.Errorf("expected error about unknown message, got %v", err)
+	}
+	if _, err := runFree("--free_field_numbers_format=xml"); err == nil || !strings.Contains(err.Error(), "unknown free field numbers format") {
+		t.Errorf("expected error about unknown format, got %v", err)
+	}
+	for _, n := range []string{"0", "536870912"} {
+		if _, err := runFree("--next_free_field_numbers=" + n); err == nil || !strings.Contains(err.Error(), "must be a positive integer") {
+			t.Errorf("%s: expected error about invalid count, got %v", n, err)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	args := []string{"goprotoc", "-I", dir, "--next_free_field_numbers=3", "--cpp_out=" + dir, "test.proto"}
+	if err := run(context.Background(), args, nil, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "with --print_free_field_numbers") {
+		t.Errorf("expected error about missing --print_free_field_numbers, got %v", err)
+	}
+}
@@ -3,11 +3,15 @@ package goprotoc
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/desc/protoparse"
@@ -17,22 +21,38 @@ import (
 
 //lint:file-ignore ST1005 capitalized errors that are sentences are command return values printed to stderr
 
-const protocVersionEmu = "3.5.1"
+const protocVersionEmu = "27.1"
 
 var (
 	version    = "dev build <no version set>" // can be replaced by -X linker flag
 	errVersion = errors.New("__version_printed__")
 	errUsage   = errors.New("__usage_printed__")
+	// errFatalWarnings causes a non-zero exit code, but there is nothing else
+	// to print since the warnings have already been printed.
+	errFatalWarnings = errors.New("__fatal_warnings__")
 )
 
-// Main is the entrypoint for the program.
+// Main is the entrypoint for the program. If the program is interrupted, any
+// plugins that are running are killed.
 func Main() {
-	os.Exit(Run(os.Args, os.Stdin, os.Stdout, os.Stderr))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := RunContext(ctx, os.Args, os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
 }
 
 // Run runs the program and returns the exit code.
 func Run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
-	if err := run(args, stdin, stdout, stderr); err != nil {
+	return RunContext(context.Background(), args, stdin, stdout, stderr)
+}
+
+// RunContext runs the program and returns the exit code. If the given context
+// is cancelled, any plugins that are running are killed and the program fails.
+func RunContext(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if err := run(ctx, args, stdin, stdout, stderr); err != nil {
+		if err == errFatalWarnings {
+			return 1
+		}
 		message := err.Error()
 		if message == "" {
 			message = "unexpected error"
@@ -43,7 +63,20 @@ func Run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int
 	return 0
 }
 
-func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	if len(args) > 1 && args[1] == "generate" {
+		return runGenerate(ctx, args[0], args[2:], stdin, stdout, stderr)
+	}
+	if len(args) > 1 && args[1] == "format" {
+		return runFormat(args[0], args[2:], stdout, stderr)
+	}
+	if len(args) > 1 && args[1] == "breaking" {
+		return runBreaking(args[0], args[2:], stdout, stderr)
+	}
+	if len(args) > 1 && args[1] == "lint" {
+		return runLint(args[0], args[2:], stdout, stderr)
+	}
+
 	var opts protocOptions
 	if err := parseFlags("", args[0], args[1:], stdout, &opts, map[string]struct{}{}); err != nil {
 		switch err {
@@ -53,21 +86,70 @@ func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) err
 			return err
 		}
 	}
+	return runWithOptions(ctx, &opts, stdin, stdout, stderr)
+}
+
+// runWithOptions runs the program with the given options, which have been
+// parsed from the command-line or loaded from a config file.
+func runWithOptions(ctx context.Context, opts *protocOptions, stdin io.Reader, stdout io.Writer, stderr io.Writer) (e error) {
+	errPrinter := &errorPrinter{format: opts.errorFormat, importPaths: opts.includePaths}
+	defer func() {
+		if e != errFatalWarnings {
+			e = errPrinter.formatError(e)
+		}
+	}()
 
 	if len(opts.inputDescriptors) > 0 && len(opts.includePaths) > 0 {
 		return errors.New("Only one of --descriptor_set_in and --proto_path can be specified.")
 	}
 
+	if opts.clearCache {
+		if opts.codeGen.cacheDir == "" {
+			return errors.New("--clear_cache requires --cache_dir.")
+		}
+		if err := clearCache(opts.codeGen.cacheDir); err != nil {
+			return fmt.Errorf("Failed to clear cache: %v", err)
+		}
+		if len(opts.protoFiles) == 0 {
+			return nil
+		}
+	}
+
 	if len(opts.protoFiles) == 0 && !opts.decodeRaw {
 		return errors.New("Missing input file.")
 	} else if len(opts.protoFiles) > 0 && opts.decodeRaw {
 		return errors.New("When using --decode_raw, no input files should be given.")
 	}
 
+	if opts.watch {
+		if len(opts.output) == 0 || opts.encodeType != "" || opts.decodeType != "" || opts.decodeRaw || opts.decodeGuess || opts.printFreeFieldNumbers ||
+			opts.printProto || opts.protoOutDir != "" {
+			return errors.New("Can only use --watch when generating code.")
+		}
+		if len(opts.inputDescriptors) > 0 {
+			return errors.New("Cannot use --watch with --descriptor_set_in.")
+		}
+		if opts.codeGen.check || opts.codeGen.diff {
+			return errors.New("Cannot use --watch with --check or --diff.")
+		}
+		return runWatch(ctx, opts, errPrinter, stdout, stderr)
+	}
+
 	var fds []*desc.FileDescriptor
 	if len(opts.protoFiles) > 0 {
 		if len(opts.inputDescriptors) > 0 {
+			for _, f := range opts.protoFiles {
+				if hasGlobMeta(filepath.ToSlash(f)) {
+					return errors.New("Input files cannot contain wildcards when using --descriptor_set_in.")
+				}
+			}
 			var err error
+			if opts.protoFiles, err = filterInputs(opts.protoFiles, opts.excludes); err != nil {
+				return err
+			}
+			if len(opts.protoFiles) == 0 {
+				return errors.New("All input files were excluded.")
+			}
 			if fds, err = loadDescriptors(opts.inputDescriptors, opts.protoFiles); err != nil {
 				return err
 			}
@@ -78,30 +160,14 @@ func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) err
 			// We could instead do a separate Parse if we wanted but the logic gets very complicated
 			// As we would want to make sure we are ONLY outputting to plugins and nothing else
 			// So that we don't have to parse twice in the general case.
-			if len(opts.output) > 0 {
+			if len(opts.output) > 0 || opts.printProto || opts.protoOutDir != "" {
 				includeSourceInfo = true
 			}
 			var err error
-			if opts.protoFiles, err = protoparse.ResolveFilenames(opts.includePaths, opts.protoFiles...); err != nil {
+			if opts.protoFiles, err = resolveInputs(opts); err != nil {
 				return err
 			}
-			var errs []error
-			p := protoparse.Parser{
-				ImportPaths:           opts.includePaths,
-				IncludeSourceCodeInfo: includeSourceInfo,
-				ErrorReporter: func(err protoparse.ErrorWithPos) error {
-					if len(errs) >= 20 {
-						return errors.New("Too many errors... aborting.")
-					}
-					errs = append(errs, err)
-					return nil
-				},
-			}
-			if fds, err = p.ParseFiles(opts.protoFiles...); err != nil && err != protoparse.ErrInvalidSource {
-				errs = append(errs, err)
-			}
-			err = toError(errs)
-			if err != nil {
+			if fds, err = parseSources(opts.protoFiles, opts, includeSourceInfo, errPrinter, stderr, nil); err != nil {
 				return err
 			}
 		}
@@ -111,46 +177,205 @@ func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) err
 	if doingCodeGen && opts.encodeType != "" {
 		return errors.New("Cannot use --encode and generate code or descriptors at the same time.")
 	}
-	if doingCodeGen && (opts.decodeType != "" || opts.decodeRaw) {
+	if doingCodeGen && (opts.decodeType != "" || opts.decodeRaw || opts.decodeGuess) {
 		return errors.New("Cannot use --decode and generate code or descriptors at the same time.")
 	}
-	if opts.encodeType != "" && (opts.decodeType != "" || opts.decodeRaw) {
+	if opts.encodeType != "" && (opts.decodeType != "" || opts.decodeRaw || opts.decodeGuess) {
 		return errors.New("Only one of --encode and --decode can be specified.")
 	}
+	if opts.dependencyOut != "" && (opts.encodeType != "" || opts.decodeType != "" || opts.decodeRaw || opts.decodeGuess || opts.printFreeFieldNumbers) {
+		return errors.New("Can only use --dependency_out=FILE when generating code.")
+	}
+	if opts.decodeRawVerbose && !opts.decodeRaw {
+		return errors.New("Can only use --decode_raw_verbose with --decode_raw.")
+	}
+	if (opts.codeGen.check || opts.codeGen.diff) && len(opts.output) == 0 {
+		return errors.New("Can only use --check or --diff when generating code.")
+	}
+	if opts.codeGen.removeStale && len(opts.output) == 0 {
+		return errors.New("Can only use --remove_stale when generating code.")
+	}
+	if opts.codeGen.summary && len(opts.output) == 0 {
+		return errors.New("Can only use --summary when generating code.")
+	}
+	if (opts.codeGen.check || opts.codeGen.diff) && (opts.outputDescriptor != "" || opts.dependencyOut != "") {
+		return errors.New("Cannot use --check or --diff with --descriptor_set_out or --dependency_out.")
+	}
+	if (opts.printProto || opts.protoOutDir != "") && (doingCodeGen || opts.encodeType != "" || opts.decodeType != "" || opts.decodeGuess || opts.printFreeFieldNumbers) {
+		return errors.New("Cannot use --print_proto or --descriptor_set_to_proto with other output directives.")
+	}
+	if opts.freeFields.isSet() && !opts.printFreeFieldNumbers {
+		return errors.New("Can only use --free_field_numbers_format, --free_field_numbers_message, or --next_free_field_numbers with --print_free_field_numbers.")
+	}
+	if opts.codec.delimited && opts.encodeType == "" && opts.decodeType == "" {
+		return errors.New("Can only use --delimited with --encode or --decode.")
+	}
 
 	var err error
 	switch {
 	case opts.encodeType != "":
-		err = doEncode(opts.encodeType, fds, stdin, stdout)
+		err = doEncode(opts.encodeType, fds, &opts.codec, stdin, stdout)
 	case opts.decodeType != "":
-		err = doDecode(opts.decodeType, fds, stdin, stdout)
+		err = doDecode(opts.decodeType, fds, &opts.codec, stdin, stdout)
 	case opts.decodeRaw:
-		err = doDecodeRaw(stdin, stdout)
+		err = doDecodeRaw(stdin, stdout, opts.decodeRawVerbose)
+	case opts.decodeGuess:
+		err = doDecodeGuess(fds, stdin, stdout)
 	case opts.printFreeFieldNumbers:
-		err = doPrintFreeFieldNumbers(fds, stdout)
+		err = doPrintFreeFieldNumbers(fds, &opts.freeFields, stdout)
+	case opts.printProto || opts.protoOutDir != "":
+		if opts.printProto {
+			err = doPrintProto(fds, opts.includeImports, stdout)
+		}
+		if err == nil && opts.protoOutDir != "" {
+			err = doDescriptorSetToProto(fds, opts.includeImports, opts.protoOutDir)
+		}
 	default:
 		if !doingCodeGen {
 			return errors.New("Missing output directives.")
 		}
-		if len(opts.output) > 0 {
-			err = doCodeGen(opts.output, fds, opts.pluginDefs)
+		err = generateOutputs(ctx, opts, fds, stdout, stderr)
+	}
+	return err
+}
+
+// generateOutputs generates code for the given files and writes the
+// descriptor set and dependency file, as requested by opts.
+func generateOutputs(ctx context.Context, opts *protocOptions, fds []*desc.FileDescriptor, stdout io.Writer, stderr io.Writer) error {
+	var outputs []string
+	if len(opts.output) > 0 {
+		opts.codeGen.stderr = stderr
+		var err error
+		if outputs, err = doCodeGen(ctx, opts.output, fds, &opts.codeGen, stdout); err != nil {
+			return err
 		}
-		if err == nil && opts.outputDescriptor != "" {
-			err = saveDescriptor(opts.outputDescriptor, fds, opts.includeImports, opts.includeSourceInfo)
+	}
+	if opts.outputDescriptor != "" {
+		if err := saveDescriptor(opts.outputDescriptor, fds, opts.includeImports, opts.includeSourceInfo); err != nil {
+			return err
 		}
+		outputs = append(outputs, opts.outputDescriptor)
 	}
-	return err
+	if opts.dependencyOut != "" {
+		return writeDependencyFile(opts.dependencyOut, outputs, fds, opts.includePaths, len(opts.inputDescriptors) > 0)
+	}
+	return nil
+}
+
+// resolveInputs expands the input files in opts (see expandInputs), resolves
+// them to names relative to the import paths, and then removes duplicates and
+// excluded files.
+func resolveInputs(opts *protocOptions) ([]string, error) {
+	fileNames, err := expandInputs(opts.includePaths, opts.protoFiles)
+	if err != nil {
+		return nil, err
+	}
+	if fileNames, err = protoparse.ResolveFilenames(opts.includePaths, fileNames...); err != nil {
+		return nil, err
+	}
+	if fileNames, err = filterInputs(fileNames, opts.excludes); err != nil {
+		return nil, err
+	}
+	if len(fileNames) == 0 {
+		return nil, errors.New("All input files were excluded.")
+	}
+	return fileNames, nil
+}
+
+// parseSources parses the given proto source files, which are relative to
+// the import paths in opts. Warnings are printed to stderr. The files in reuse,
+// keyed by name, are not parsed again: the given descriptors are used instead.
+// The caller must make sure that none of their dependencies have changed.
+func parseSources(fileNames []string, opts *protocOptions, includeSourceInfo bool, errPrinter *errorPrinter, stderr io.Writer, reuse map[string]*desc.FileDescriptor) ([]*desc.FileDescriptor, error) {
+	var errs []error
+	var numWarnings int
+	p := protoparse.Parser{
+		ImportPaths:           opts.includePaths,
+		IncludeSourceCodeInfo: includeSourceInfo,
+		ErrorReporter: func(err protoparse.ErrorWithPos) error {
+			if len(errs) >= 20 {
+				return errors.New("Too many errors... aborting.")
+			}
+			errs = append(errs, err)
+			return nil
+		},
+		WarningReporter: func(err protoparse.ErrorWithPos) {
+			numWarnings++
+			errPrinter.printWarning(stderr, err)
+		},
+	}
+	if len(reuse) > 0 {
+		// Hide the sources of the reused files, so that they are instead
+		// found via LookupImport.
+		hidden := map[string]struct{}{}
+		for name := range reuse {
+			if len(opts.includePaths) == 0 {
+				hidden[filepath.FromSlash(name)] = struct{}{}
+			}
+			for _, importPath := range opts.includePaths {
+				hidden[filepath.Join(importPath, filepath.FromSlash(name))] = struct{}{}
+			}
+		}
+		p.Accessor = func(fileName string) (io.ReadCloser, error) {
+			if _, ok := hidden[filepath.Clean(fileName)]; ok {
+				return nil, &os.PathError{Op: "open", Path: fileName, Err: os.ErrNotExist}
+			}
+			return os.Open(fileName)
+		}
+		p.LookupImport = func(fileName string) (*desc.FileDescriptor, error) {
+			if fd, ok := reuse[fileName]; ok {
+				return fd, nil
+			}
+			return nil, &os.PathError{Op: "open", Path: fileName, Err: os.ErrNotExist}
+		}
+	}
+	fds, err := p.ParseFiles(fileNames...)
+	if err != nil && err != protoparse.ErrInvalidSource {
+		errs = append(errs, err)
+	}
+	if err := toError(errs); err != nil {
+		return nil, err
+	}
+	if opts.fatalWarnings && numWarnings > 0 {
+		return nil, errFatalWarnings
+	}
+	return fds, nil
+}
+
+// usesOutputOptions returns true if any options were given, other than --check
+// and --diff, that choose what to do with the parsed files or where they come
+// from. These are not allowed with commands that only check source files.
+func usesOutputOptions(opts *protocOptions) bool {
+	return len(opts.output) > 0 || len(opts.inputDescriptors) > 0 || opts.outputDescriptor != "" || opts.dependencyOut != "" ||
+		opts.encodeType != "" || opts.decodeType != "" || opts.decodeRaw || opts.decodeGuess || opts.printFreeFieldNumbers ||
+		opts.printProto || opts.protoOutDir != "" || opts.watch
 }
 
 func usage(programName string, stdout io.Writer) error {
 	_, err := fmt.Fprintf(
 		stdout,
-		`Usage: %s [OPTION] PROTO_FILES
-Parse PROTO_FILES and generate output based on the options given:
+		`Usage: %[1]s [OPTION] PROTO_FILES
+       %[1]s generate [--config=FILE] [OPTION] [PROTO_FILES]
+       %[1]s format [--check] [--diff] [OPTION] PROTO_FILES
+       %[1]s breaking --against=OLD [--category=CATEGORY] [OPTION] PROTO_FILES
+       %[1]s lint [--config=FILE] [OPTION] [PROTO_FILES]
+Parse PROTO_FILES and generate output based on the options given. Each of
+PROTO_FILES may also be a directory, which means all .proto files under it,
+or a pattern in which '*', '?', and '[...]' match within a path element and
+'**' matches any number of directories, such as 'foo/**/*.proto'. Patterns
+and directories are matched relative to each import path and to the working
+directory, and only files inside an import path are used. The files they
+match are sorted, and any duplicates are ignored.
   -IPATH, --proto_path=PATH   Specify the directory in which to search for
                               imports.  May be specified multiple times;
                               directories will be searched in order.  If not
                               given, the current working directory is used.
+                              PATH may also be a delimited list of
+                              directories.
+  --exclude=PATTERN           Do not use the PROTO_FILES whose names
+                              relative to their import path match PATTERN,
+                              which may use the same wildcards as
+                              PROTO_FILES. May be specified multiple times.
   --version                   Show version info and exit.
   -h, --help                  Show this text and exit.
   --encode=MESSAGE_TYPE       Read a text-format message of the given type
@@ -161,11 +386,41 @@ Parse PROTO_FILES and generate output based on the options given:
                               standard input and write it in text format
                               to standard output.  The message type must
                               be defined in PROTO_FILES or their imports.
+  --encode_format=FORMAT      The format of encoded messages: those written
+                              by --encode and read by --decode. FORMAT may
+                              be 'binary' (the default), 'text', or 'json'.
+  --decode_format=FORMAT      The format of decoded messages: those read by
+                              --encode and written by --decode. FORMAT may
+                              be 'text' (the default), 'binary', or 'json'.
+                              Together with --encode_format, this allows
+                              converting between any two formats.
+  --json_emit_defaults        When writing JSON, include fields that have
+                              their default value.
+  --json_use_proto_names      When writing JSON, use field names as they
+                              appear in the proto source instead of their
+                              lowerCamelCase JSON names.
+  --delimited                 With --encode or --decode, the binary data is
+                              a stream of messages, each preceded by its
+                              length as a varint. When the decoded format is
+                              text, messages are separated by blank lines.
   --decode_raw                Read an arbitrary protocol message from
                               standard input and write the raw tag/value
                               pairs in text format to standard output.  No
                               PROTO_FILES should be given when using this
                               flag.
+  --decode_raw_verbose        With --decode_raw, annotate each field with its
+                              byte offset and length and with every way its
+                              value could be interpreted (signed, zigzag,
+                              unsigned, float, hex). Byte fields that parse
+                              as a sequence of varints are also shown as
+                              packed repeated values.
+  --decode_guess              Like --decode_raw, but scores every message
+                              type defined in PROTO_FILES (and their
+                              imports) against the data read from standard
+                              input, reports the most likely types, and
+                              decodes the data using the best match. Fields
+                              not defined in that type are written in raw
+                              form with a comment.
   --descriptor_set_in=FILES   Specifies a delimited list of FILES
                               each containing a FileDescriptorSet (a
                               protocol buffer defined in descriptor.proto).
@@ -179,18 +434,113 @@ Parse PROTO_FILES and generate output based on the options given:
                               the input files to FILE.
   --include_imports           When using --descriptor_set_out, also include
                               all dependencies of the input files in the
-                              set, so that the set is self-contained.
+                              set, so that the set is self-contained. When
+                              using --print_proto or
+                              --descriptor_set_to_proto, also write source
+                              for all dependencies of the input files.
   --include_source_info       When using --descriptor_set_out, do not strip
                               SourceCodeInfo from the FileDescriptorProto.
                               This results in vastly larger descriptors that
                               include information about the original
                               location of each decl in the source file as
                               well as surrounding comments.
+  --print_proto               Write .proto source for the input files to
+                              standard output, reconstructed from their
+                              descriptors. This is most useful with
+                              --descriptor_set_in. The source includes
+                              options, extensions, and reserved ranges, and
+                              comments if the descriptors include
+                              SourceCodeInfo.
+  --descriptor_set_to_proto=DIR
+                              Like --print_proto, but write the source for
+                              each file into DIR, at a path that matches the
+                              file's name.
+  --dependency_out=FILE       Write a dependency output file in the format
+                              expected by make. This writes the transitive
+                              set of input file paths to FILE, with the
+                              generated outputs as the targets.
+  --error_format=FORMAT       Set the format in which to print errors.
+                              FORMAT may be 'gcc' (the default), 'msvs'
+                              (Microsoft Visual Studio format), or 'json'
+                              (one JSON object per line, with file, line,
+                              column, severity, and message properties).
+  --fatal_warnings            Make warnings be fatal (similar to -Werr in
+                              gcc). This flag will make goprotoc return
+                              with non-zero exit code if any warnings
+                              are generated.
+  --experimental_allow_proto3_optional
+                              Accepted for compatibility with older versions
+                              of protoc. Proto3 optional fields are always
+                              allowed.
+  --experimental_editions     Disables checks that plugins support the
+                              editions used by PROTO_FILES. Plugins that do
+                              not advertise support for editions, or for
+                              the particular editions used, will be given
+                              the files anyway.
+  --jobs=N                    Run at most N plugins concurrently. Defaults to
+                              the number of CPUs.
+  --check                     Instead of writing generated files, compare
+                              them with the files on disk and fail if any
+                              are stale or missing. With --remove_stale,
+                              also fail if any files that were generated by
+                              a previous run would no longer be generated.
+  --diff                      Like --check, but also print a unified diff
+                              of the out-of-date files to standard output.
+  --remove_stale              Delete files from output directories that
+                              were generated by a previous run but are no
+                              longer generated. The files generated for each
+                              --NAME_out directory are recorded in a hidden
+                              manifest file, .goprotoc_NAME_out.manifest, in
+                              that directory. With --check or --diff, such
+                              files are reported instead of deleted.
+  --summary                   After generating code, print the files that
+                              were created, updated, or removed, and the
+                              number of files that were unchanged. Files
+                              whose contents are unchanged are never
+                              rewritten.
+  --plugin_timeout=DURATION   Kill any plugin that runs for longer than the
+                              given duration, such as "30s" or "5m".
+                              In-process plugins can't be killed, but they
+                              also fail with a timeout.
+  --cache_dir=DIR             Cache the responses of plugin programs in DIR.
+                              A plugin is not run if the same plugin binary
+                              was previously given the same request.
+  --cache_max_size=SIZE       The maximum total size of the cache, in bytes,
+                              with an optional K, M, or G suffix. When the
+                              cache is larger, the least recently used
+                              entries are removed. Defaults to 1G.
+  --no_cache                  Do not use the cache, even if --cache_dir is
+                              given.
+  --clear_cache               Remove all entries from the cache in the
+                              directory given by --cache_dir. If no
+                              PROTO_FILES are given, goprotoc exits after
+                              clearing the cache.
+  --watch                     Keep running after generating code, and
+                              generate it again whenever .proto files in
+                              the import paths change. Only the changed
+                              files and the files that import them are
+                              parsed again. Errors are printed, and then
+                              goprotoc waits for the next change.
   --print_free_field_numbers  Print the free field numbers of the messages
                               defined in the given proto files. Groups share
                               the same field number space with the parent
-                              message. Extension ranges are counted as
+                              message. Extension ranges and the numbers
+                              19000 to 19999, which are reserved for the
+                              protobuf implementation, are counted as
                               occupied fields numbers.
+  --free_field_numbers_format=FORMAT
+                              The format of --print_free_field_numbers
+                              output. FORMAT may be 'text' (the default),
+                              'json', or 'yaml'.
+  --free_field_numbers_message=NAME
+                              With --print_free_field_numbers, only print
+                              the message with the given fully-qualified
+                              name. May be given more than once.
+  --next_free_field_numbers=N With --print_free_field_numbers, print the N
+                              lowest free field numbers of each message
+                              instead of all free ranges. N must be no
+                              greater than 536870911, the highest field
+                              number.
   --plugin=EXECUTABLE         Specifies a plugin executable to use.
                               Normally, protoc searches the PATH for
                               plugins, but you may specify additional
@@ -227,11 +577,115 @@ Parse PROTO_FILES and generate output based on the options given:
                               quotes, wildcards, escapes, commands, etc.).
                               Each line corresponds to a single argument,
                               even if it contains spaces.
+
+Commands:
+  generate                    Generate code as configured by a goprotoc.yaml
+                              file, which declares the import paths, input
+                              files (which may use wildcards, with '**'
+                              matching any number of directories), and the
+                              plugins to run along with their parameters and
+                              output locations. The file is found by
+                              searching the working directory and then its
+                              parents, unless --config=FILE is given.
+                              Relative paths in the file are relative to the
+                              directory that contains it. Other options
+                              given on the command-line override the file,
+                              and PROTO_FILES, if given, replace its inputs.
+  format                      Rewrite PROTO_FILES in a canonical style. The
+                              files are printed from their parsed form, so
+                              indentation and spacing are normalized,
+                              imports are sorted, and options are sorted by
+                              name, with standard options before custom
+                              ones. Comments and blank lines are preserved,
+                              but repeated blank lines become one, and
+                              top-level definitions are always separated by
+                              a blank line. With --check,
+                              files are not rewritten, and the command fails
+                              if any are not formatted. --diff is like
+                              --check but also prints the changes that
+                              formatting would make. Only --proto_path,
+                              --exclude, --error_format, and
+                              --fatal_warnings may also be given.
+  breaking                    Compare PROTO_FILES with an older version of
+                              them and report changes that are not
+                              backwards-compatible, such as deleted or
+                              renumbered fields, changed field types,
+                              labels, or JSON names, deleted enum values,
+                              services, or methods, a renamed package, or a
+                              method that changed streaming. OLD is either a
+                              descriptor set file or a directory with the
+                              older source files, relative to which their
+                              imports are also resolved before the import
+                              paths. Files that are not in OLD are skipped.
+                              Files in OLD that are not among PROTO_FILES or
+                              their imports, and that can't be found in the
+                              import paths, are reported as deleted, along
+                              with their services.
+                              Changes are reported as errors, in the format
+                              given by --error_format.
+                              --category=CATEGORY limits the changes
+                              reported to those that break the given kinds
+                              of compatibility, as a comma-separated list:
+                              'WIRE' (the binary format), 'WIRE_JSON' (the
+                              binary or JSON format), or 'SOURCE'
+                              (generated code). By default, all are
+                              reported.
+  lint                        Check PROTO_FILES for problems with style and
+                              hygiene. If a goprotoc.yaml file is found, as
+                              for generate, or given with --config=FILE, its
+                              import paths and inputs are used, and its
+                              'lint' section may 'enable' and 'disable'
+                              rules by name, list patterns of files to
+                              'ignore', and map rules to patterns of files
+                              they should ignore in 'ignore_only'. A
+                              problem is also ignored if the element, or
+                              one that encloses it, has a comment with a
+                              line like 'lint:ignore RULE'. The rules are:
+                                PACKAGE_LOWER_SNAKE_CASE
+                                PACKAGE_DIRECTORY_MATCH
+                                MESSAGE_PASCAL_CASE
+                                FIELD_LOWER_SNAKE_CASE
+                                ONEOF_LOWER_SNAKE_CASE
+                                ENUM_PASCAL_CASE
+                                ENUM_VALUE_UPPER_SNAKE_CASE
+                                ENUM_ZERO_VALUE_SUFFIX
+                                SERVICE_PASCAL_CASE
+                                RPC_PASCAL_CASE
+                                RESERVED_NAMES_CASE
+                                RESERVED_NUMBERS_AND_NAMES
+                              and these, which must be enabled:
+                                FIELD_NUMBER_GAPS
+                                COMMENT_MESSAGE
+                                COMMENT_FIELD
+                                COMMENT_ENUM
+                                COMMENT_SERVICE
+                                COMMENT_RPC
 `, programName)
 	return err
 }
 
 func loadDescriptors(descFileNames []string, inputProtoFiles []string) ([]*desc.FileDescriptor, error) {
+	allFiles, err := readDescriptorSets(descFileNames)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*desc.FileDescriptor, len(inputProtoFiles))
+	linked := map[string]*desc.FileDescriptor{}
+	for i, protoName := range inputProtoFiles {
+		if _, ok := allFiles[protoName]; !ok {
+			return nil, fmt.Errorf("file not found: %q", protoName)
+		}
+		result[i], err = linkFile(protoName, allFiles, linked, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not load %q: %v", protoName, err)
+		}
+	}
+	return result, nil
+}
+
+// readDescriptorSets reads the given descriptor set files and returns the
+// files in them, keyed by name.
+func readDescriptorSets(descFileNames []string) (map[string]*descriptorpb.FileDescriptorProto, error) {
 	allFiles := map[string]*descriptorpb.FileDescriptorProto{}
 	for _, fileName := range descFileNames {
 		d, err := os.ReadFile(fileName)
@@ -250,19 +704,7 @@ func loadDescriptors(descFileNames []string, inputProtoFiles []string) ([]*desc.
 			}
 		}
 	}
-	result := make([]*desc.FileDescriptor, len(inputProtoFiles))
-	linked := map[string]*desc.FileDescriptor{}
-	for i, protoName := range inputProtoFiles {
-		if _, ok := allFiles[protoName]; !ok {
-			return nil, fmt.Errorf("file not found: %q", protoName)
-		}
-		var err error
-		result[i], err = linkFile(protoName, allFiles, linked, nil)
-		if err != nil {
-			return nil, fmt.Errorf("could not load %q: %v", protoName, err)
-		}
-	}
-	return result, nil
+	return allFiles, nil
 }
 
 func linkFile(fileName string, fds map[string]*descriptorpb.FileDescriptorProto, linkedFds map[string]*desc.FileDescriptor, seen []string) (*desc.FileDescriptor, error) {
@@ -0,0 +1,391 @@
+package goprotoc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxGuessesReported is the number of likely message types that are reported
+// by --decode_guess.
+const maxGuessesReported = 5
+
+// typeMatch describes how well a message type matches some wire data.
+type typeMatch struct {
+	md *desc.MessageDescriptor
+	// matched is the number of fields in the data that are defined in the
+	// message type with a compatible wire type.
+	matched int
+	// total is the total number of fields in the data. Fields in nested
+	// messages and groups are included in both counts.
+	total int
+}
+
+func (m typeMatch) score() float64 {
+	if m.total == 0 {
+		return 0
+	}
+	return float64(m.matched) / float64(m.total)
+}
+
+func doDecodeGuess(fds []*desc.FileDescriptor, r io.Reader, w io.Writer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !isProbablyMessage(data) {
+		return errors.New("input is not a valid protocol buffer message")
+	}
+	matches := guessMessageTypes(data, fds)
+	if len(matches) == 0 {
+		if _, err := fmt.Fprintln(w, "# No message types match the input"); err != nil {
+			return err
+		}
+		return decodeRawMessage(newCodedReader(data), w, "", false)
+	}
+
+	if _, err := fmt.Fprintln(w, "# Likely message types:"); err != nil {
+		return err
+	}
+	for i, m := range matches {
+		if i == maxGuessesReported {
+			break
+		}
+		if _, err := fmt.Fprintf(w, "#   %s: %d of %d fields match (%.0f%%)\n", m.md.GetFullyQualifiedName(), m.matched, m.total, m.score()*100); err != nil {
+			return err
+		}
+	}
+	best := matches[0].md
+	if _, err := fmt.Fprintf(w, "# Decoded as %s:\n", best.GetFullyQualifiedName()); err != nil {
+		return err
+	}
+	return decodeGuessedMessage(newCodedReader(data), best, w, "", false)
+}
+
+// guessMessageTypes scores every message type in the given files (and their
+// dependencies) against the given data. It returns the types that match at
+// least one field, best matches first.
+func guessMessageTypes(data []byte, fds []*desc.FileDescriptor) []typeMatch {
+	var matches []typeMatch
+	for _, md := range allMessageTypes(fds) {
+		matched, total, ok := scoreMessage(newCodedReader(data), md, false)
+		if !ok || matched == 0 {
+			continue
+		}
+		matches = append(matches, typeMatch{md: md, matched: matched, total: total})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		si, sj := matches[i].score(), matches[j].score()
+		if si != sj {
+			return si > sj
+		}
+		// when the score is the same, prefer the type that is the closer fit,
+		// with fewer fields that are absent from the data
+		ni, nj := len(matches[i].md.GetFields()), len(matches[j].md.GetFields())
+		if ni != nj {
+			return ni < nj
+		}
+		return matches[i].md.GetFullyQualifiedName() < matches[j].md.GetFullyQualifiedName()
+	})
+	return matches
+}
+
+func allMessageTypes(fds []*desc.FileDescriptor) []*desc.MessageDescriptor {
+	var mds []*desc.MessageDescriptor
+	seen := map[string]struct{}{}
+	var addMessages func(msgs []*desc.MessageDescriptor)
+	addMessages = func(msgs []*desc.MessageDescriptor) {
+		for _, md := range msgs {
+			if md.IsMapEntry() {
+				continue
+			}
+			mds = append(mds, md)
+			addMessages(md.GetNestedMessageTypes())
+		}
+	}
+	var addFile func(fd *desc.FileDescriptor)
+	addFile = func(fd *desc.FileDescriptor) {
+		if _, ok := seen[fd.GetName()]; ok {
+			return
+		}
+		seen[fd.GetName()] = struct{}{}
+		addMessages(fd.GetMessageTypes())
+		for _, dep := range fd.GetDependencies() {
+			addFile(dep)
+		}
+	}
+	for _, fd := range fds {
+		addFile(fd)
+	}
+	return mds
+}
+
+// scoreMessage counts the fields in the given data and how many of them match
+// the given message type. If the data is not a well-formed message, ok is false.
+func scoreMessage(in *codedReader, md *desc.MessageDescriptor, inGroup bool) (matched, total int, ok bool) {
+	for {
+		if in.eof() {
+			return matched, total, !inGroup
+		}
+		t, wt, err := in.decodeTagAndWireType()
+		if err != nil {
+			return 0, 0, false
+		}
+		if wt == protowire.EndGroupType {
+			return matched, total, inGroup
+		}
+		start := in.index
+		if !skipField(in, wt) {
+			return 0, 0, false
+		}
+		total++
+		fd := md.FindFieldByNumber(t)
+		if fd == nil || !isCompatibleWireType(fd, wt) {
+			continue
+		}
+		switch fd.UnwrapField().Kind() {
+		case protoreflect.MessageKind:
+			v, _ := newCodedReader(in.buf[start:]).decodeRawBytes(false)
+			nestedMatched, nestedTotal, ok := scoreMessage(newCodedReader(v), fd.GetMessageType(), false)
+			if !ok {
+				continue
+			}
+			matched += nestedMatched
+			total += nestedTotal
+		case protoreflect.GroupKind:
+			nestedMatched, nestedTotal, _ := scoreMessage(newCodedReader(in.buf[start:in.index]), fd.GetMessageType(), true)
+			matched += nestedMatched
+			total += nestedTotal
+		case protoreflect.StringKind:
+			v, _ := newCodedReader(in.buf[start:]).decodeRawBytes(false)
+			if !utf8.Valid(v) {
+				continue
+			}
+		}
+		matched++
+	}
+}
+
+// skipField advances past the value of a field with the given wire type. It
+// returns false if the value is malformed.
+func skipField(in *codedReader, wt protowire.Type) bool {
+	switch wt {
+	case protowire.VarintType:
+		_, err := in.decodeVarint()
+		return err == nil
+	case protowire.Fixed32Type:
+		return in.skip(4)
+	case protowire.Fixed64Type:
+		return in.skip(8)
+	case protowire.BytesType:
+		_, err := in.decodeRawBytes(false)
+		return err == nil
+	case protowire.StartGroupType:
+		return in.isProbablyMessage(true)
+	default:
+		return false
+	}
+}
+
+// isCompatibleWireType returns true if a value for the given field could be
+// encoded using the given wire type.
+func isCompatibleWireType(fd *desc.FieldDescriptor, wt protowire.Type) bool {
+	kind := fd.UnwrapField().Kind()
+	expected := wireTypeForKind(kind)
+	if wt == expected {
+		return true
+	}
+	// repeated scalar numeric fields may also be packed
+	return wt == protowire.BytesType && fd.IsRepeated() && !fd.IsMap() && expected != protowire.BytesType && expected != protowire.StartGroupType
+}
+
+func wireTypeForKind(kind protoreflect.Kind) protowire.Type {
+	switch kind {
+	case protoreflect.BoolKind, protoreflect.EnumKind,
+		protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Uint32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Uint64Kind:
+		return protowire.VarintType
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind, protoreflect.FloatKind:
+		return protowire.Fixed32Type
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind, protoreflect.DoubleKind:
+		return protowire.Fixed64Type
+	case protoreflect.GroupKind:
+		return protowire.StartGroupType
+	default:
+		// strings, bytes, and messages
+		return protowire.BytesType
+	}
+}
+
+// decodeGuessedMessage is like decodeRawMessage except that it uses the given
+// message type to print field names and to interpret values. Fields that are
+// not defined in the message type, or whose wire type does not match the
+// definition, are printed in raw form and annotated with a comment.
+func decodeGuessedMessage(in *codedReader, md *desc.MessageDescriptor, w io.Writer, indent string, inGroup bool) error {
+	for {
+		if in.eof() {
+			if inGroup {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		}
+		fieldStart := in.index
+		t, wt, err := in.decodeTagAndWireType()
+		if err != nil {
+			return err
+		}
+		if wt == protowire.EndGroupType {
+			if inGroup {
+				return nil
+			}
+			return fmt.Errorf("input contains unexpected 'end group' wire type")
+		}
+		valueStart := in.index
+		if !skipField(in, wt) {
+			return fmt.Errorf("input contains malformed value for tag number %d", t)
+		}
+		fd := md.FindFieldByNumber(t)
+		var annotation string
+		switch {
+		case fd == nil:
+			annotation = "unknown field"
+		case !isCompatibleWireType(fd, wt):
+			annotation = fmt.Sprintf("wrong wire type for field %s", fd.GetName())
+		}
+		if annotation != "" {
+			if err := decodeAnnotatedRawField(in.buf[fieldStart:in.index], w, indent, annotation); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value := newCodedReader(in.buf[valueStart:in.index])
+		kind := fd.UnwrapField().Kind()
+		switch {
+		case kind == protoreflect.GroupKind:
+			if _, err := fmt.Fprintf(w, "%s%s {\n", indent, fd.GetName()); err != nil {
+				return err
+			}
+			if err := decodeGuessedMessage(value, fd.GetMessageType(), w, indent+"  ", true); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "%s}\n", indent); err != nil {
+				return err
+			}
+		case kind == protoreflect.MessageKind:
+			v, _ := value.decodeRawBytes(false)
+			if !isProbablyMessage(v) {
+				if err := decodeAnnotatedRawField(in.buf[fieldStart:in.index], w, indent, fmt.Sprintf("invalid value for field %s", fd.GetName())); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%s%s: <\n", indent, fd.GetName()); err != nil {
+				return err
+			}
+			if err := decodeGuessedMessage(newCodedReader(v), fd.GetMessageType(), w, indent+"  ", false); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "%s>\n", indent); err != nil {
+				return err
+			}
+		case kind == protoreflect.StringKind || kind == protoreflect.BytesKind:
+			v, _ := value.decodeRawBytes(false)
+			if _, err := fmt.Fprintf(w, "%s%s: %s\n", indent, fd.GetName(), quoteString(v)); err != nil {
+				return err
+			}
+		case wt == protowire.BytesType:
+			// packed repeated field
+			v, _ := value.decodeRawBytes(false)
+			packed := newCodedReader(v)
+			for !packed.eof() {
+				s, err := formatScalarValue(packed, fd, wireTypeForKind(kind))
+				if err != nil {
+					return fmt.Errorf("input contains malformed packed value for field %s: %v", fd.GetName(), err)
+				}
+				if _, err := fmt.Fprintf(w, "%s%s: %s\n", indent, fd.GetName(), s); err != nil {
+					return err
+				}
+			}
+		default:
+			s, err := formatScalarValue(value, fd, wt)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "%s%s: %s\n", indent, fd.GetName(), s); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// decodeAnnotatedRawField prints the given encoded field (tag and value) in
+// raw form, like --decode_raw, with a comment on the first line.
+func decodeAnnotatedRawField(field []byte, w io.Writer, indent, annotation string) error {
+	var buf bytes.Buffer
+	if err := decodeRawMessage(newCodedReader(field), &buf, indent, false); err != nil {
+		return err
+	}
+	s := buf.String()
+	if pos := strings.IndexByte(s, '\n'); pos >= 0 {
+		s = s[:pos] + "  # " + annotation + s[pos:]
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// formatScalarValue reads a single numeric value for the given field from in,
+// encoded with the given wire type, and returns its text representation.
+func formatScalarValue(in *codedReader, fd *desc.FieldDescriptor, wt protowire.Type) (string, error) {
+	var v uint64
+	var err error
+	switch wt {
+	case protowire.VarintType:
+		v, err = in.decodeVarint()
+	case protowire.Fixed32Type:
+		v, err = in.decodeFixed32()
+	case protowire.Fixed64Type:
+		v, err = in.decodeFixed64()
+	default:
+		return "", fmt.Errorf("invalid wire type for scalar value: %d", wt)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch fd.UnwrapField().Kind() {
+	case protoreflect.BoolKind:
+		return strconv.FormatBool(v != 0), nil
+	case protoreflect.EnumKind:
+		if vd := fd.GetEnumType().FindValueByNumber(int32(v)); vd != nil {
+			return vd.GetName(), nil
+		}
+		return strconv.FormatInt(int64(int32(v)), 10), nil
+	case protoreflect.Int32Kind, protoreflect.Sfixed32Kind:
+		return strconv.FormatInt(int64(int32(v)), 10), nil
+	case protoreflect.Int64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(int64(v), 10), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return strconv.FormatUint(uint64(uint32(v)), 10), nil
+	case protoreflect.Sint32Kind:
+		return strconv.FormatInt(protowire.DecodeZigZag(v&math.MaxUint32), 10), nil
+	case protoreflect.Sint64Kind:
+		return strconv.FormatInt(protowire.DecodeZigZag(v), 10), nil
+	case protoreflect.FloatKind:
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(v))), 'g', -1, 32), nil
+	case protoreflect.DoubleKind:
+		return strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64), nil
+	default:
+		return strconv.FormatUint(v, 10), nil
+	}
+}
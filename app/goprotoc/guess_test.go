@@ -0,0 +1,54 @@
+package goprotoc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+func TestDecodeGuess(t *testing.T) {
+	fds := parseTestFiles(t, map[string]string{
+		"test.proto": `
+			syntax = "proto3";
+			message Person { string name = 1; sint32 delta = 2; repeated int32 ids = 3; Address addr = 4; }
+			message Address { string street = 1; string city = 2; }
+			message Other { bytes data = 1; double d = 2; }`,
+	}, "test.proto")
+
+	dm := dynamic.NewMessage(fds[0].FindMessage("Person"))
+	dm.SetFieldByName("name", "bob")
+	dm.SetFieldByName("delta", int32(-3))
+	dm.SetFieldByName("ids", []int32{1, 2})
+	addr := dynamic.NewMessage(fds[0].FindMessage("Address"))
+	addr.SetFieldByName("city", "nyc")
+	dm.SetFieldByName("addr", addr)
+	data, err := dm.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// add an unknown field
+	data = append(data, 0x28, 0x07)
+
+	var out bytes.Buffer
+	if err := doDecodeGuess(fds, bytes.NewReader(data), &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# Likely message types:
+#   Person: 5 of 6 fields match (83%)
+#   Address: 1 of 5 fields match (20%)
+#   Other: 1 of 5 fields match (20%)
+# Decoded as Person:
+name: "bob"
+delta: -3
+ids: 1
+ids: 2
+addr: <
+  city: "nyc"
+>
+5: 7  # unknown field
+`
+	if out.String() != expected {
+		t.Errorf("wrong output:\nexpected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
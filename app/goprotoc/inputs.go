@@ -0,0 +1,273 @@
+package goprotoc
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// expandInputs expands the given input files, any of which may be a pattern
+// (see expandGlob) or a directory, which is the same as the pattern
+// "DIR/**/*.proto". Patterns are matched relative to each of the given import
+// paths and also relative to the working directory, though only files inside
+// an import path are included. If there are no import paths, the working
+// directory is used. Each pattern must match at least one file.
+//
+// The files matched by each pattern are sorted, and names that are not
+// patterns are returned unchanged. Files are not deduplicated here since
+// inputs may name the same file in different ways; see filterInputs.
+func expandInputs(importPaths, inputs []string) ([]string, error) {
+	var absImportPaths []string
+	for _, importPath := range importPaths {
+		abs, err := filepath.Abs(importPath)
+		if err != nil {
+			return nil, err
+		}
+		absImportPaths = append(absImportPaths, abs)
+	}
+	roots := importPaths
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	var results []string
+	for _, input := range inputs {
+		pattern := filepath.ToSlash(input)
+		if !hasGlobMeta(pattern) {
+			if !isInputDir(roots, input) {
+				results = append(results, input)
+				continue
+			}
+			pattern = path.Join(pattern, "**/*.proto")
+		}
+
+		seen := map[string]struct{}{}
+		var matches []string
+		add := func(fileName string) error {
+			abs, err := filepath.Abs(fileName)
+			if err != nil {
+				return err
+			}
+			if _, ok := seen[abs]; !ok {
+				seen[abs] = struct{}{}
+				matches = append(matches, fileName)
+			}
+			return nil
+		}
+		if !path.IsAbs(pattern) {
+			for _, root := range roots {
+				files, err := globFiles(root, pattern)
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range files {
+					if err := add(f); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		if len(importPaths) > 0 {
+			// also match relative to the working directory (or, for absolute
+			// patterns, the file system root)
+			files, err := globFiles(".", pattern)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range files {
+				abs, err := filepath.Abs(f)
+				if err != nil {
+					return nil, err
+				}
+				if !inAnyDir(absImportPaths, abs) {
+					continue
+				}
+				if err := add(f); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", input)
+		}
+		sort.Strings(matches)
+		results = append(results, matches...)
+	}
+	return results, nil
+}
+
+// isInputDir reports whether the given input names a directory, either
+// relative to the working directory or to one of the given roots.
+func isInputDir(roots []string, input string) bool {
+	candidates := []string{input}
+	if !filepath.IsAbs(input) {
+		for _, root := range roots {
+			candidates = append(candidates, filepath.Join(root, input))
+		}
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func inAnyDir(dirs []string, fileName string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(fileName, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterInputs removes duplicates and any files that match one of the given
+// exclude patterns from the given resolved file names, which are relative to
+// an import path. The order of the remaining files is preserved.
+func filterInputs(fileNames, excludes []string) ([]string, error) {
+	excludeElements := make([][]string, len(excludes))
+	for i, exclude := range excludes {
+		elements, err := splitGlob(exclude)
+		if err != nil {
+			return nil, err
+		}
+		excludeElements[i] = elements
+	}
+	seen := map[string]struct{}{}
+	results := make([]string, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		if _, ok := seen[fileName]; ok {
+			continue
+		}
+		seen[fileName] = struct{}{}
+		excluded := false
+		for _, elements := range excludeElements {
+			if matchGlob(elements, strings.Split(fileName, "/")) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			results = append(results, fileName)
+		}
+	}
+	return results, nil
+}
+
+// expandGlob returns the sorted paths of the files that match the given
+// pattern, which is relative to dir and uses "/" as the path separator.
+// Patterns use the syntax of path.Match, except that an element that is
+// exactly "**" matches any number of directories, including none. It is an
+// error if no files match.
+func expandGlob(dir, pattern string) ([]string, error) {
+	matches, err := globFiles(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files match %q", pattern)
+	}
+	return matches, nil
+}
+
+// globFiles is like expandGlob, except that it is not an error if no files
+// match. If the pattern is an absolute path, dir is ignored.
+func globFiles(dir, pattern string) ([]string, error) {
+	elements, err := splitGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if path.IsAbs(pattern) {
+		dir = ""
+	}
+	// walk only the directory named by the leading elements that have no
+	// wildcards
+	literal := 0
+	for literal < len(elements) && !hasGlobMeta(elements[literal]) {
+		literal++
+	}
+	base := filepath.Join(dir, filepath.FromSlash(strings.Join(elements[:literal], "/")))
+	if literal == len(elements) {
+		info, err := os.Stat(base)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if info.IsDir() {
+			return nil, nil
+		}
+		return []string{base}, nil
+	}
+
+	var matches []string
+	rest := elements[literal:]
+	err = filepath.WalkDir(base, func(fileName string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if fileName == base && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, fileName)
+		if err != nil {
+			return err
+		}
+		if matchGlob(rest, strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, fileName)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// splitGlob cleans the given pattern and splits it into its elements. It
+// returns an error if any element is not a valid pattern.
+func splitGlob(pattern string) ([]string, error) {
+	elements := strings.Split(path.Clean(pattern), "/")
+	for _, element := range elements {
+		if _, err := path.Match(element, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return elements, nil
+}
+
+// matchGlob reports whether the given path elements match the given pattern
+// elements. See expandGlob for the pattern syntax.
+func matchGlob(pattern, elements []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elements); i++ {
+				if matchGlob(pattern[1:], elements[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elements) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elements[0]); !ok {
+			return false
+		}
+		pattern, elements = pattern[1:], elements[1:]
+	}
+	return len(elements) == 0
+}
+
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
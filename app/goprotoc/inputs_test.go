@@ -0,0 +1,182 @@
+package goprotoc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jhump/goprotoc/plugins"
+)
+
+func TestExpandGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.proto":       "",
+		"x/b.proto":     "",
+		"x/y/c.proto":   "",
+		"x/y/d.txt":     "",
+		"z/y/e.proto":   "",
+		"z/y/w/f.proto": "",
+	})
+	testCases := []struct {
+		pattern  string
+		expected []string
+	}{
+		{pattern: "a.proto", expected: []string{"a.proto"}},
+		{pattern: "*.proto", expected: []string{"a.proto"}},
+		{pattern: "**/*.proto", expected: []string{"a.proto", "x/b.proto", "x/y/c.proto", "z/y/e.proto", "z/y/w/f.proto"}},
+		{pattern: "x/**", expected: []string{"x/b.proto", "x/y/c.proto", "x/y/d.txt"}},
+		{pattern: "*/y/*.proto", expected: []string{"x/y/c.proto", "z/y/e.proto"}},
+		{pattern: "**/y/**/*.proto", expected: []string{"x/y/c.proto", "z/y/e.proto", "z/y/w/f.proto"}},
+		{pattern: "./x/../z/y/?.proto", expected: []string{"z/y/e.proto"}},
+	}
+	for _, tc := range testCases {
+		matches, err := expandGlob(dir, tc.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", tc.pattern, err)
+			continue
+		}
+		var actual []string
+		for _, m := range matches {
+			rel, err := filepath.Rel(dir, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual = append(actual, filepath.ToSlash(rel))
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.pattern, tc.expected, actual)
+		}
+	}
+
+	for _, pattern := range []string{"nope.proto", "*.txt", "q/**/*.proto"} {
+		if _, err := expandGlob(dir, pattern); err == nil || !strings.Contains(err.Error(), "no files match") {
+			t.Errorf("%s: expected no matches, got %v", pattern, err)
+		}
+	}
+	if _, err := expandGlob(dir, "[a.proto"); err == nil || !strings.Contains(err.Error(), "invalid pattern") {
+		t.Errorf("expected invalid pattern error, got %v", err)
+	}
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"proto/a/a.proto":     "",
+		"proto/a/b/b.proto":   "",
+		"proto/c.proto":       "",
+		"proto/c.txt":         "",
+		"other/a/z.proto":     "",
+		"third_party/d.proto": "",
+	})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+
+	testCases := []struct {
+		importPaths []string
+		inputs      []string
+		expected    []string
+	}{
+		{
+			// no import paths: relative to the working directory
+			inputs:   []string{"proto/**/*.proto", "x.proto"},
+			expected: []string{"proto/a/a.proto", "proto/a/b/b.proto", "proto/c.proto", "x.proto"},
+		},
+		{
+			// relative to the import path
+			importPaths: []string{"proto"},
+			inputs:      []string{"a"},
+			expected:    []string{"proto/a/a.proto", "proto/a/b/b.proto"},
+		},
+		{
+			// relative to the import paths and to the working directory, but
+			// only inside an import path
+			importPaths: []string{"proto", "third_party"},
+			inputs:      []string{"*/*.proto"},
+			expected:    []string{"proto/a/a.proto", "proto/c.proto", "third_party/d.proto"},
+		},
+		{
+			importPaths: []string{"proto", "other"},
+			inputs:      []string{"a/*.proto", "proto/a/**"},
+			expected:    []string{"other/a/z.proto", "proto/a/a.proto", "proto/a/a.proto", "proto/a/b/b.proto"},
+		},
+	}
+	for _, tc := range testCases {
+		actual, err := expandInputs(tc.importPaths, tc.inputs)
+		if err != nil {
+			t.Errorf("%v: %v", tc.inputs, err)
+			continue
+		}
+		for i := range actual {
+			actual[i] = filepath.ToSlash(actual[i])
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%v: expected %v, got %v", tc.inputs, tc.expected, actual)
+		}
+	}
+
+	if _, err := expandInputs([]string{"proto"}, []string{"other/**/*.proto"}); err == nil || !strings.Contains(err.Error(), "no files match") {
+		t.Errorf("expected no matches outside of import paths, got %v", err)
+	}
+}
+
+func TestFilterInputs(t *testing.T) {
+	files := []string{"foo/a.proto", "bar/b.proto", "foo/a.proto", "foo/internal/c.proto", "d.proto"}
+	actual, err := filterInputs(files, []string{"**/internal/**", "d.*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"foo/a.proto", "bar/b.proto"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestRun_InputPatterns(t *testing.T) {
+	registerTestPlugin(t, "test_inputs", func(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error {
+		var names []string
+		for _, fd := range req.Files {
+			names = append(names, fd.GetName())
+		}
+		_, err := resp.OutputFile("files.txt").Write([]byte(strings.Join(names, " ")))
+		return err
+	})
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"proto/foo/foo.proto":        `syntax = "proto3"; package foo; message Foo {}`,
+		"proto/foo/internal/x.proto": `syntax = "proto3"; package foo.internal; message X {}`,
+		"proto/bar/bar.proto":        `syntax = "proto3"; package bar; import "foo/foo.proto"; message Bar { foo.Foo foo = 1; }`,
+		"gen/.keep":                  ``,
+	})
+	var stdout, stderr bytes.Buffer
+	args := []string{
+		"goprotoc",
+		"-I", filepath.Join(dir, "proto"),
+		"--exclude=**/internal/**",
+		"--test_inputs_out=" + filepath.Join(dir, "gen"),
+		filepath.Join(dir, "proto/foo/foo.proto"),
+		filepath.Join(dir, "proto"),
+	}
+	if err := run(context.Background(), args, nil, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "gen", "files.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "foo/foo.proto bar/bar.proto"; string(b) != expected {
+		t.Errorf("wrong files: expected %q, got %q", expected, string(b))
+	}
+}
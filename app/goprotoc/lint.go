@@ -0,0 +1,574 @@
+package goprotoc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/bufbuild/protocompile/ast"
+	"github.com/bufbuild/protocompile/reporter"
+	"github.com/jhump/protoreflect/desc"
+)
+
+//lint:file-ignore ST1005 capitalized errors that are sentences are command return values printed to stderr
+
+// LintRule checks a proto source file and reports any problems that it finds
+// to the given reporter. The file always includes source code info, so the
+// comments and positions of its elements are available.
+type LintRule func(fd *desc.FileDescriptor, r *LintReporter)
+
+// LintReporter collects the problems found by a LintRule.
+type LintReporter struct {
+	rule     string
+	problems []lintProblem
+}
+
+type lintProblem struct {
+	rule    string
+	element desc.Descriptor
+	pos     ast.SourcePos
+	message string
+}
+
+// Report reports a problem with the given element, which must be in the file
+// that is being checked. The problem is ignored if the element, or any element
+// that encloses it, has a comment with a line of the form
+// "lint:ignore RULE_NAME", optionally followed by a reason.
+func (r *LintReporter) Report(d desc.Descriptor, format string, args ...interface{}) {
+	r.problems = append(r.problems, lintProblem{
+		rule:    r.rule,
+		element: d,
+		pos:     sourcePos(d),
+		message: fmt.Sprintf(format, args...),
+	})
+}
+
+type registeredLintRule struct {
+	check            LintRule
+	enabledByDefault bool
+}
+
+var (
+	lintRules   = map[string]registeredLintRule{}
+	lintRulesMu sync.RWMutex
+)
+
+// RegisterLintRule registers a rule that is run by the lint command, in
+// addition to the built-in rules. The given name is used to enable, disable, or
+// ignore the rule. By convention, it is in UPPER_SNAKE_CASE. Registered rules
+// run by default, unless disabled in the lint configuration.
+//
+// This function will panic if the given name is already used by a rule.
+//
+// This function is safe to call concurrently, but it should usually be invoked
+// during program initialization, before other functions in this package are
+// invoked to run the goprotoc tool.
+func RegisterLintRule(name string, rule LintRule) {
+	registerLintRule(name, rule, true)
+}
+
+func registerLintRule(name string, rule LintRule, enabledByDefault bool) {
+	lintRulesMu.Lock()
+	defer lintRulesMu.Unlock()
+	if _, ok := lintRules[name]; ok {
+		panic(fmt.Sprintf("lint rule already registered for %q", name))
+	}
+	lintRules[name] = registeredLintRule{check: rule, enabledByDefault: enabledByDefault}
+}
+
+// unregisterLintRule removes the lint rule registered with the given name, if
+// any. It is used by tests, which must not leave rules registered.
+func unregisterLintRule(name string) {
+	lintRulesMu.Lock()
+	defer lintRulesMu.Unlock()
+	delete(lintRules, name)
+}
+
+// runLint implements the lint command, which checks the given files with the
+// lint rules. If there is a goprotoc.yaml file, its import paths and inputs
+// are used, like for the generate command, along with its lint configuration.
+func runLint(programName string, args []string, stdout io.Writer, stderr io.Writer) error {
+	configFiles, args, err := extractFlag(args, "--config")
+	if err != nil {
+		return err
+	}
+	var configFile string
+	if len(configFiles) > 0 {
+		configFile = configFiles[len(configFiles)-1]
+	} else if configFile, err = searchWorkspaceConfig(); err != nil {
+		return err
+	}
+
+	var opts protocOptions
+	lintConf := &workspaceLintConfig{}
+	if configFile != "" {
+		conf, err := loadWorkspaceConfig(configFile)
+		if err != nil {
+			return err
+		}
+		if err := conf.apply(filepath.Dir(configFile), &opts); err != nil {
+			return fmt.Errorf("%s: %v", configFile, err)
+		}
+		// the plugins are only used by the generate command
+		opts.output, opts.codeGen = nil, codeGenOptions{}
+		if conf.Lint != nil {
+			lintConf = conf.Lint
+		}
+	}
+	numConfigFiles := len(opts.protoFiles)
+	if err := parseFlags("", programName, args, stdout, &opts, map[string]struct{}{}); err != nil {
+		switch err {
+		case errVersion, errUsage:
+			return nil
+		default:
+			return err
+		}
+	}
+	if len(opts.protoFiles) > numConfigFiles {
+		// files given on the command-line replace those in the config
+		opts.protoFiles = opts.protoFiles[numConfigFiles:]
+	}
+	return lintWithOptions(&opts, lintConf, stderr)
+}
+
+func lintWithOptions(opts *protocOptions, conf *workspaceLintConfig, stderr io.Writer) (e error) {
+	errPrinter := &errorPrinter{format: opts.errorFormat, importPaths: opts.includePaths}
+	defer func() {
+		if e != errFatalWarnings {
+			e = errPrinter.formatError(e)
+		}
+	}()
+
+	if len(opts.protoFiles) == 0 {
+		return errors.New("Missing input file.")
+	}
+	if usesOutputOptions(opts) || opts.codeGen.check || opts.codeGen.diff {
+		return errors.New("Only --proto_path, --exclude, --config, --error_format, and --fatal_warnings can be used with lint.")
+	}
+	rules, err := conf.rules()
+	if err != nil {
+		return err
+	}
+
+	fileNames, err := resolveInputs(opts)
+	if err != nil {
+		return err
+	}
+	fds, err := parseSources(fileNames, opts, true, errPrinter, stderr, nil)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var problems []lintProblem
+	for _, fd := range fds {
+		for _, name := range names {
+			ignored, err := conf.ignores(name, fd.GetName())
+			if err != nil {
+				return err
+			}
+			if ignored {
+				continue
+			}
+			r := &LintReporter{rule: name}
+			rules[name](fd, r)
+			for _, p := range r.problems {
+				if !isLintIgnored(p.element, name) {
+					problems = append(problems, p)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i].pos, problems[j].pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Col < b.Col
+	})
+	errs := make([]error, len(problems))
+	for i, p := range problems {
+		errs[i] = reporter.Error(ast.NewSourceSpan(p.pos, p.pos), fmt.Errorf("%s (%s)", p.message, p.rule))
+	}
+	return toError(errs)
+}
+
+// rules returns the rules to run, keyed by name.
+func (c *workspaceLintConfig) rules() (map[string]LintRule, error) {
+	lintRulesMu.RLock()
+	defer lintRulesMu.RUnlock()
+	for _, names := range [][]string{c.Enable, c.Disable} {
+		for _, name := range names {
+			if _, ok := lintRules[name]; !ok {
+				return nil, fmt.Errorf("Unknown lint rule: %s", name)
+			}
+		}
+	}
+	for name := range c.IgnoreOnly {
+		if _, ok := lintRules[name]; !ok {
+			return nil, fmt.Errorf("Unknown lint rule: %s", name)
+		}
+	}
+	rules := map[string]LintRule{}
+	for name, rule := range lintRules {
+		if rule.enabledByDefault {
+			rules[name] = rule.check
+		}
+	}
+	for _, name := range c.Enable {
+		rules[name] = lintRules[name].check
+	}
+	for _, name := range c.Disable {
+		delete(rules, name)
+	}
+	return rules, nil
+}
+
+// ignores returns true if the given rule should not check the given file,
+// whose name is relative to an import path.
+func (c *workspaceLintConfig) ignores(rule, fileName string) (bool, error) {
+	elements := strings.Split(fileName, "/")
+	for _, patterns := range [][]string{c.Ignore, c.IgnoreOnly[rule]} {
+		for _, pattern := range patterns {
+			patternElements, err := splitGlob(pattern)
+			if err != nil {
+				return false, err
+			}
+			if matchGlob(patternElements, elements) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// isLintIgnored returns true if the given element, or any element that
+// encloses it, has a "lint:ignore" comment for the given rule. For the file,
+// comments on the syntax and package statements are used.
+func isLintIgnored(d desc.Descriptor, rule string) bool {
+	for ; d != nil; d = d.GetParent() {
+		fd, ok := d.(*desc.FileDescriptor)
+		if !ok {
+			si := d.GetSourceInfo()
+			if hasLintIgnore(si.GetLeadingComments(), rule) || hasLintIgnore(si.GetTrailingComments(), rule) {
+				return true
+			}
+			continue
+		}
+		for _, loc := range fd.AsFileDescriptorProto().GetSourceCodeInfo().GetLocation() {
+			if len(loc.Path) != 1 || (loc.Path[0] != fileSyntaxTag && loc.Path[0] != filePackageTag) {
+				continue
+			}
+			comments := append([]string{loc.GetLeadingComments(), loc.GetTrailingComments()}, loc.LeadingDetachedComments...)
+			for _, comment := range comments {
+				if hasLintIgnore(comment, rule) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func hasLintIgnore(comment, rule string) bool {
+	for _, line := range strings.Split(comment, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "lint:ignore" && fields[1] == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	registerLintRule("PACKAGE_LOWER_SNAKE_CASE", lintPackageCase, true)
+	registerLintRule("PACKAGE_DIRECTORY_MATCH", lintPackageDirectory, true)
+	registerLintRule("MESSAGE_PASCAL_CASE", lintMessageCase, true)
+	registerLintRule("FIELD_LOWER_SNAKE_CASE", lintFieldCase, true)
+	registerLintRule("ONEOF_LOWER_SNAKE_CASE", lintOneofCase, true)
+	registerLintRule("ENUM_PASCAL_CASE", lintEnumCase, true)
+	registerLintRule("ENUM_VALUE_UPPER_SNAKE_CASE", lintEnumValueCase, true)
+	registerLintRule("ENUM_ZERO_VALUE_SUFFIX", lintEnumZeroValue, true)
+	registerLintRule("SERVICE_PASCAL_CASE", lintServiceCase, true)
+	registerLintRule("RPC_PASCAL_CASE", lintMethodCase, true)
+	registerLintRule("RESERVED_NAMES_CASE", lintReservedNamesCase, true)
+	registerLintRule("RESERVED_NUMBERS_AND_NAMES", lintReservedNumbersAndNames, true)
+	registerLintRule("FIELD_NUMBER_GAPS", lintFieldNumberGaps, false)
+	registerLintRule("COMMENT_MESSAGE", lintCommentMessage, false)
+	registerLintRule("COMMENT_FIELD", lintCommentField, false)
+	registerLintRule("COMMENT_ENUM", lintCommentEnum, false)
+	registerLintRule("COMMENT_SERVICE", lintCommentService, false)
+	registerLintRule("COMMENT_RPC", lintCommentMethod, false)
+}
+
+var (
+	lowerSnakeCase = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+	upperSnakeCase = regexp.MustCompile(`^[A-Z][A-Z0-9]*(_[A-Z0-9]+)*$`)
+	pascalCase     = regexp.MustCompile(`^[A-Z][a-zA-Z0-9]*$`)
+)
+
+// allLintMessages returns all messages in the given file, including nested
+// messages, but not map entries.
+func allLintMessages(fd *desc.FileDescriptor) []*desc.MessageDescriptor {
+	var mds []*desc.MessageDescriptor
+	var add func([]*desc.MessageDescriptor)
+	add = func(msgs []*desc.MessageDescriptor) {
+		for _, md := range msgs {
+			if md.IsMapEntry() {
+				continue
+			}
+			mds = append(mds, md)
+			add(md.GetNestedMessageTypes())
+		}
+	}
+	add(fd.GetMessageTypes())
+	return mds
+}
+
+// allLintEnums returns all enums in the given file, including nested enums.
+func allLintEnums(fd *desc.FileDescriptor) []*desc.EnumDescriptor {
+	eds := fd.GetEnumTypes()
+	for _, md := range allLintMessages(fd) {
+		eds = append(eds, md.GetNestedEnumTypes()...)
+	}
+	return eds
+}
+
+func lintPackageCase(fd *desc.FileDescriptor, r *LintReporter) {
+	if fd.GetPackage() == "" {
+		return
+	}
+	for _, component := range strings.Split(fd.GetPackage(), ".") {
+		if !lowerSnakeCase.MatchString(component) {
+			r.Report(fd, "package %q should be lower_snake_case", fd.GetPackage())
+			return
+		}
+	}
+}
+
+func lintPackageDirectory(fd *desc.FileDescriptor, r *LintReporter) {
+	if fd.GetPackage() == "" {
+		return
+	}
+	want := strings.ReplaceAll(fd.GetPackage(), ".", "/")
+	if dir := path.Dir(fd.GetName()); dir != want {
+		r.Report(fd, "file with package %q should be in directory %q, not %q", fd.GetPackage(), want, dir)
+	}
+}
+
+func lintMessageCase(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, md := range allLintMessages(fd) {
+		if !pascalCase.MatchString(md.GetName()) {
+			r.Report(md, "message name %q should be PascalCase", md.GetName())
+		}
+	}
+}
+
+func lintFieldCase(fd *desc.FileDescriptor, r *LintReporter) {
+	fields := fd.GetExtensions()
+	for _, md := range allLintMessages(fd) {
+		fields = append(fields, md.GetFields()...)
+		fields = append(fields, md.GetNestedExtensions()...)
+	}
+	for _, fld := range fields {
+		if !lowerSnakeCase.MatchString(fld.GetName()) {
+			r.Report(fld, "field name %q should be lower_snake_case", fld.GetName())
+		}
+	}
+}
+
+func lintOneofCase(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, md := range allLintMessages(fd) {
+		for _, oo := range md.GetOneOfs() {
+			if !oo.IsSynthetic() && !lowerSnakeCase.MatchString(oo.GetName()) {
+				r.Report(oo, "oneof name %q should be lower_snake_case", oo.GetName())
+			}
+		}
+	}
+}
+
+func lintEnumCase(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, ed := range allLintEnums(fd) {
+		if !pascalCase.MatchString(ed.GetName()) {
+			r.Report(ed, "enum name %q should be PascalCase", ed.GetName())
+		}
+	}
+}
+
+func lintEnumValueCase(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, ed := range allLintEnums(fd) {
+		for _, vd := range ed.GetValues() {
+			if !upperSnakeCase.MatchString(vd.GetName()) {
+				r.Report(vd, "enum value name %q should be UPPER_SNAKE_CASE", vd.GetName())
+			}
+		}
+	}
+}
+
+func lintEnumZeroValue(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, ed := range allLintEnums(fd) {
+		want := toUpperSnakeCase(ed.GetName()) + "_UNSPECIFIED"
+		for _, vd := range ed.GetValues() {
+			if vd.GetNumber() == 0 && vd.GetName() != want {
+				r.Report(vd, "zero value of enum %q should be named %s", ed.GetName(), want)
+				break
+			}
+		}
+	}
+}
+
+func lintServiceCase(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, sd := range fd.GetServices() {
+		if !pascalCase.MatchString(sd.GetName()) {
+			r.Report(sd, "service name %q should be PascalCase", sd.GetName())
+		}
+	}
+}
+
+func lintMethodCase(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, sd := range fd.GetServices() {
+		for _, mtd := range sd.GetMethods() {
+			if !pascalCase.MatchString(mtd.GetName()) {
+				r.Report(mtd, "method name %q should be PascalCase", mtd.GetName())
+			}
+		}
+	}
+}
+
+func lintReservedNamesCase(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, md := range allLintMessages(fd) {
+		for _, name := range md.AsDescriptorProto().GetReservedName() {
+			if !lowerSnakeCase.MatchString(name) {
+				r.Report(md, "reserved name %q of message %q should be lower_snake_case, like field names", name, md.GetName())
+			}
+		}
+	}
+	for _, ed := range allLintEnums(fd) {
+		for _, name := range ed.AsEnumDescriptorProto().GetReservedName() {
+			if !upperSnakeCase.MatchString(name) {
+				r.Report(ed, "reserved name %q of enum %q should be UPPER_SNAKE_CASE, like enum value names", name, ed.GetName())
+			}
+		}
+	}
+}
+
+// lintReservedNumbersAndNames checks that elements that reserve the numbers
+// of deleted fields or values also reserve their names, and vice versa, since
+// the numbers are used in the binary format and the names in JSON.
+func lintReservedNumbersAndNames(fd *desc.FileDescriptor, r *LintReporter) {
+	check := func(d desc.Descriptor, kind string, numbers, names int) {
+		switch {
+		case numbers > 0 && names == 0:
+			r.Report(d, "%s %q reserves numbers but no names; also reserve the names of deleted fields so they can't be reused in JSON", kind, d.GetName())
+		case names > 0 && numbers == 0:
+			r.Report(d, "%s %q reserves names but no numbers; also reserve the numbers of deleted fields so they can't be reused in the binary format", kind, d.GetName())
+		}
+	}
+	for _, md := range allLintMessages(fd) {
+		mdp := md.AsDescriptorProto()
+		check(md, "message", len(mdp.GetReservedRange()), len(mdp.GetReservedName()))
+	}
+	for _, ed := range allLintEnums(fd) {
+		edp := ed.AsEnumDescriptorProto()
+		check(ed, "enum", len(edp.GetReservedRange()), len(edp.GetReservedName()))
+	}
+}
+
+// lintFieldNumberGaps checks that the field numbers of each message, below
+// the highest one in use, are all either used or reserved.
+func lintFieldNumberGaps(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, md := range allLintMessages(fd) {
+		var highest int32
+		for _, fld := range md.GetFields() {
+			if fld.GetNumber() > highest {
+				highest = fld.GetNumber()
+			}
+		}
+		var gaps []string
+		for _, free := range computeFreeRanges(md) {
+			if free.start > highest {
+				break
+			}
+			if free.start == free.end {
+				gaps = append(gaps, fmt.Sprint(free.start))
+			} else {
+				gaps = append(gaps, fmt.Sprintf("%d-%d", free.start, free.end))
+			}
+		}
+		if len(gaps) > 0 {
+			r.Report(md, "message %q skips field numbers %s; reserve them if they belonged to deleted fields", md.GetName(), strings.Join(gaps, ", "))
+		}
+	}
+}
+
+func lintCommentMessage(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, md := range allLintMessages(fd) {
+		requireLintComment(md, "message", r)
+	}
+}
+
+func lintCommentField(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, md := range allLintMessages(fd) {
+		for _, fld := range md.GetFields() {
+			requireLintComment(fld, "field", r)
+		}
+	}
+}
+
+func lintCommentEnum(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, ed := range allLintEnums(fd) {
+		requireLintComment(ed, "enum", r)
+	}
+}
+
+func lintCommentService(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, sd := range fd.GetServices() {
+		requireLintComment(sd, "service", r)
+	}
+}
+
+func lintCommentMethod(fd *desc.FileDescriptor, r *LintReporter) {
+	for _, sd := range fd.GetServices() {
+		for _, mtd := range sd.GetMethods() {
+			requireLintComment(mtd, "method", r)
+		}
+	}
+}
+
+func requireLintComment(d desc.Descriptor, kind string, r *LintReporter) {
+	if strings.TrimSpace(d.GetSourceInfo().GetLeadingComments()) == "" {
+		r.Report(d, "%s %q should have a leading comment", kind, d.GetName())
+	}
+}
+
+// toUpperSnakeCase converts the given PascalCase name to UPPER_SNAKE_CASE.
+func toUpperSnakeCase(name string) string {
+	var sb strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return sb.String()
+}
@@ -0,0 +1,195 @@
+package goprotoc
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+var lintTestFiles = map[string]string{
+	"foo/v1/good.proto": `syntax = "proto3";
+package foo.v1;
+message GoodMessage {
+  string field_name = 1;
+  oneof choice {
+    string a = 2;
+    GoodEnum b = 3;
+  }
+  map<string, int32> counts = 4;
+  reserved 5;
+  reserved "old_name";
+}
+enum GoodEnum {
+  GOOD_ENUM_UNSPECIFIED = 0;
+  GOOD_ENUM_VALUE = 1;
+}
+service GoodService {
+  rpc DoIt(GoodMessage) returns (GoodMessage);
+}
+`,
+	"bad.proto": `syntax = "proto3";
+package Bad.pkg;
+
+message bad_message {
+  string FieldName = 1;
+  oneof Choice {
+    string a = 2;
+    string b = 4;
+  }
+  reserved 10;
+  reserved "oldName";
+}
+
+enum color {
+  RED = 0;
+  blue = 1;
+}
+
+enum Size {
+  // lint:ignore ENUM_ZERO_VALUE_SUFFIX the zero value is a real size
+  SMALL = 0;
+}
+
+service svc {
+  rpc do_it(bad_message) returns (bad_message);
+}
+`,
+}
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, lintTestFiles)
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"goprotoc", "lint", "--config=" + filepath.Join(dir, "none.yaml"), "-I", dir, "foo/v1/good.proto"}
+	if err := run(context.Background(), args, nil, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "none.yaml") {
+		t.Errorf("expected error about missing config, got %v", err)
+	}
+	writeTestFiles(t, dir, map[string]string{"goprotoc.yaml": ``})
+	config := "--config=" + filepath.Join(dir, "goprotoc.yaml")
+	args = []string{"goprotoc", "lint", config, "-I", dir, "foo/v1/good.proto"}
+	if err := run(context.Background(), args, nil, &stdout, &stderr); err != nil {
+		t.Errorf("good.proto should have no problems: %v", err)
+	}
+
+	args = []string{"goprotoc", "lint", config, "-I", dir, "bad.proto"}
+	err := run(context.Background(), args, nil, &stdout, &stderr)
+	expected := []string{
+		`bad.proto:2:1: file with package "Bad.pkg" should be in directory "Bad/pkg", not "." (PACKAGE_DIRECTORY_MATCH)`,
+		`bad.proto:2:1: package "Bad.pkg" should be lower_snake_case (PACKAGE_LOWER_SNAKE_CASE)`,
+		`bad.proto:4:1: message name "bad_message" should be PascalCase (MESSAGE_PASCAL_CASE)`,
+		`bad.proto:4:1: reserved name "oldName" of message "bad_message" should be lower_snake_case, like field names (RESERVED_NAMES_CASE)`,
+		`bad.proto:5:3: field name "FieldName" should be lower_snake_case (FIELD_LOWER_SNAKE_CASE)`,
+		`bad.proto:6:3: oneof name "Choice" should be lower_snake_case (ONEOF_LOWER_SNAKE_CASE)`,
+		`bad.proto:14:1: enum name "color" should be PascalCase (ENUM_PASCAL_CASE)`,
+		`bad.proto:15:3: zero value of enum "color" should be named COLOR_UNSPECIFIED (ENUM_ZERO_VALUE_SUFFIX)`,
+		`bad.proto:16:3: enum value name "blue" should be UPPER_SNAKE_CASE (ENUM_VALUE_UPPER_SNAKE_CASE)`,
+		`bad.proto:24:1: service name "svc" should be PascalCase (SERVICE_PASCAL_CASE)`,
+		`bad.proto:25:3: method name "do_it" should be PascalCase (RPC_PASCAL_CASE)`,
+	}
+	if err == nil || err.Error() != strings.Join(expected, "\n") {
+		t.Errorf("wrong problems reported:\n%v", err)
+	}
+}
+
+func TestLint_Config(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, lintTestFiles)
+	writeTestFiles(t, dir, map[string]string{
+		"goprotoc.yaml": `
+proto_path: [.]
+inputs: [bad.proto]
+lint:
+  enable: [FIELD_NUMBER_GAPS, COMMENT_SERVICE]
+  disable: [PACKAGE_DIRECTORY_MATCH]
+  ignore_only:
+    MESSAGE_PASCAL_CASE: ["*.proto"]
+    ENUM_PASCAL_CASE: ["foo/**"]
+`,
+	})
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"goprotoc", "lint", "--config", filepath.Join(dir, "goprotoc.yaml"), "--error_format=msvs"}
+	err := run(context.Background(), args, nil, &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected problems")
+	}
+	problems := err.Error()
+	for _, rule := range []string{"FIELD_NUMBER_GAPS", "COMMENT_SERVICE", "ENUM_PASCAL_CASE"} {
+		if !strings.Contains(problems, "("+rule+")") {
+			t.Errorf("expected a problem from %s:\n%s", rule, problems)
+		}
+	}
+	for _, rule := range []string{"PACKAGE_DIRECTORY_MATCH", "MESSAGE_PASCAL_CASE", "COMMENT_MESSAGE"} {
+		if strings.Contains(problems, "("+rule+")") {
+			t.Errorf("expected no problems from %s:\n%s", rule, problems)
+		}
+	}
+	if expected := `(4) : error in column=1: message "bad_message" skips field numbers 3; reserve them if they belonged to deleted fields (FIELD_NUMBER_GAPS)`; !strings.Contains(problems, expected) {
+		t.Errorf("expected %q in problems:\n%s", expected, problems)
+	}
+
+	writeTestFiles(t, dir, map[string]string{
+		"goprotoc.yaml": "inputs: [bad.proto]\nlint:\n  disable: [NO_SUCH_RULE]\n",
+	})
+	err = run(context.Background(), args, nil, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "Unknown lint rule: NO_SUCH_RULE") {
+		t.Errorf("expected error about unknown rule, got %v", err)
+	}
+}
+
+func TestRegisterLintRule(t *testing.T) {
+	RegisterLintRule("TEST_NO_LEGACY_MESSAGES", func(fd *desc.FileDescriptor, r *LintReporter) {
+		for _, md := range fd.GetMessageTypes() {
+			if strings.HasPrefix(md.GetName(), "Legacy") {
+				r.Report(md, "message %q should not be legacy", md.GetName())
+			}
+		}
+	})
+	t.Cleanup(func() {
+		unregisterLintRule("TEST_NO_LEGACY_MESSAGES")
+	})
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"goprotoc.yaml": ``,
+		"legacy.proto": `syntax = "proto3";
+message LegacyFoo {}
+// lint:ignore TEST_NO_LEGACY_MESSAGES still in use
+message LegacyBar {}
+`,
+	})
+	var stdout, stderr bytes.Buffer
+	args := []string{"goprotoc", "lint", "--config", filepath.Join(dir, "goprotoc.yaml"), "-I", dir, "legacy.proto"}
+	err := run(context.Background(), args, nil, &stdout, &stderr)
+	if err == nil || err.Error() != `legacy.proto:2:1: message "LegacyFoo" should not be legacy (TEST_NO_LEGACY_MESSAGES)` {
+		t.Errorf("wrong problems reported: %v", err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("registering a rule with the same name should panic")
+			}
+		}()
+		RegisterLintRule("ENUM_PASCAL_CASE", func(fd *desc.FileDescriptor, r *LintReporter) {})
+	}()
+}
+
+func TestToUpperSnakeCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Color":      "COLOR",
+		"HTTPMethod": "HTTP_METHOD",
+		"FooBarBaz":  "FOO_BAR_BAZ",
+		"Version2":   "VERSION2",
+		"V2Api":      "V2_API",
+	} {
+		if actual := toUpperSnakeCase(name); actual != expected {
+			t.Errorf("toUpperSnakeCase(%q): expected %s, got %s", name, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package goprotoc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jhump/goprotoc/plugins"
+)
+
+// manifestPath returns the path of the manifest that records the files that
+// the given output generated into the given directory.
+func manifestPath(dir, lang string) string {
+	return filepath.Join(dir, ".goprotoc_"+lang+"_out.manifest")
+}
+
+// readManifest reads the names of the files listed in the manifest at the
+// given path. If there is no such manifest, it returns nil. Names that are
+// not clean relative paths are ignored, so a corrupt or malicious manifest
+// cannot cause files outside the output directory to be removed.
+func readManifest(fileName string) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !isSafeRelativePath(line) {
+			continue
+		}
+		names = append(names, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", fileName, err)
+	}
+	return names, nil
+}
+
+// isSafeRelativePath reports whether the given slash-separated path is a clean
+// relative path that does not refer to the parent directory, so that it
+// cannot name a file outside the directory it is relative to.
+func isSafeRelativePath(name string) bool {
+	return !path.IsAbs(name) && path.Clean(name) == name && name != ".." && !strings.HasPrefix(name, "../")
+}
+
+func writeManifest(fileName, lang string, names []string) error {
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "# Files generated by goprotoc for --%s_out. DO NOT EDIT.\n", lang)
+	for _, name := range names {
+		_, _ = fmt.Fprintln(&buf, name)
+	}
+	_, err := writeFileResult(fileName, &buf)
+	return err
+}
+
+// createdFiles returns the sorted names of the files created by each output,
+// keyed by output name. Only outputs written to directories are included,
+// since archives are always rewritten in full.
+func createdFiles(resps map[string]*plugins.CodeGenResponse, locations map[string]outputLocation) (map[string][]string, error) {
+	created := map[string][]string{}
+	for lang, resp := range resps {
+		if locations[lang].locationType != outputTypeDir {
+			continue
+		}
+		var names []string
+		err := resp.ForEach(func(name, insertionPoint string, _ io.Reader) error {
+			if insertionPoint == "" {
+				names = append(names, path.Clean(filepath.ToSlash(name)))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(names)
+		created[lang] = names
+	}
+	return created, nil
+}
+
+// findStaleOutputs returns the sorted paths of files that, according to the
+// manifests in the output directories, were generated by a previous run but
+// are not generated by this one. Files that this run generates for a different
+// output are not considered stale.
+func findStaleOutputs(created map[string][]string, locations map[string]outputLocation, results map[outputFile]io.Reader) ([]string, error) {
+	generated := map[string]struct{}{}
+	for file := range results {
+		if file.loc.locationType == outputTypeDir {
+			generated[filepath.Join(file.loc.path, file.fileName)] = struct{}{}
+		}
+	}
+
+	var stale []string
+	for lang, names := range created {
+		dir := locations[lang].path
+		previous, err := readManifest(manifestPath(dir, lang))
+		if err != nil {
+			return nil, err
+		}
+		current := make(map[string]struct{}, len(names))
+		for _, name := range names {
+			current[name] = struct{}{}
+		}
+		for _, name := range previous {
+			if _, ok := current[name]; ok {
+				continue
+			}
+			fileName := filepath.Join(dir, filepath.FromSlash(name))
+			if _, ok := generated[fileName]; ok {
+				continue
+			}
+			if _, err := os.Stat(fileName); os.IsNotExist(err) {
+				// already gone
+				continue
+			}
+			stale = append(stale, fileName)
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
+
+// removeStaleOutputs deletes the given stale files and any directories that
+// are left empty as a result, up to (but not including) the output directory
+// that contained the file.
+func removeStaleOutputs(stale []string, locations map[string]outputLocation) error {
+	for _, fileName := range stale {
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var root string
+		for _, loc := range locations {
+			if loc.locationType == outputTypeDir && strings.HasPrefix(fileName, loc.path+string(filepath.Separator)) && len(loc.path) > len(root) {
+				root = loc.path
+			}
+		}
+		for dir := filepath.Dir(fileName); len(dir) > len(root) && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+			if err := os.Remove(dir); err != nil {
+				// not empty (or otherwise can't be removed), so leave it
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// updateManifests records the files created by each output in the manifest in
+// its output directory.
+func updateManifests(created map[string][]string, locations map[string]outputLocation) error {
+	for lang, names := range created {
+		if err := writeManifest(manifestPath(locations[lang].path, lang), lang, names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
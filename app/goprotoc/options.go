@@ -5,7 +5,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type protocOptions struct {
@@ -13,14 +15,26 @@ type protocOptions struct {
 	encodeType            string
 	decodeType            string
 	decodeRaw             bool
+	decodeRawVerbose      bool
+	decodeGuess           bool
+	codec                 codecOptions
 	inputDescriptors      []string
 	outputDescriptor      string
 	includeImports        bool
 	includeSourceInfo     bool
+	dependencyOut         string
+	errorFormat           errorFormat
+	fatalWarnings         bool
 	printFreeFieldNumbers bool
-	pluginDefs            map[string]string
+	freeFields            freeFieldsOptions
+	codeGen               codeGenOptions
+	clearCache            bool
 	output                map[string]string
 	protoFiles            []string
+	excludes              []string
+	watch                 bool
+	printProto            bool
+	protoOutDir           string
 }
 
 func parseFlags(source string, programName string, args []string, stdout io.Writer, opts *protocOptions, sourcesSeen map[string]struct{}) error {
@@ -85,7 +99,16 @@ func parseFlags(source string, programName string, args []string, stdout io.Writ
 			if err != nil {
 				return err
 			}
-			opts.includePaths = append(opts.includePaths, value)
+			opts.includePaths = append(opts.includePaths, splitPathList(value)...)
+		case "--exclude":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			if _, err := splitGlob(value); err != nil {
+				return fmt.Errorf("%svalue for option %s: %v", loc(), parts[0], err)
+			}
+			opts.excludes = append(opts.excludes, value)
 		case "--version":
 			if err := noOptionArg(); err != nil {
 				return err
@@ -114,18 +137,62 @@ func parseFlags(source string, programName string, args []string, stdout io.Writ
 				return err
 			}
 			opts.decodeType = value
+		case "--encode_format", "--decode_format":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			format, err := parseMessageFormat(value)
+			if err != nil {
+				return fmt.Errorf("%s%s: %v", loc(), parts[0], err)
+			}
+			if parts[0] == "--encode_format" {
+				opts.codec.encodedFormat = format
+			} else {
+				opts.codec.decodedFormat = format
+			}
+		case "--json_emit_defaults":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.codec.jsonEmitDefaults = value
+		case "--json_use_proto_names":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.codec.jsonUseProtoNames = value
+		case "--delimited":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.codec.delimited = value
 		case "--decode_raw":
 			value, err := getBoolArg()
 			if err != nil {
 				return err
 			}
 			opts.decodeRaw = value
+		case "--decode_raw_verbose":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.decodeRawVerbose = value
+		case "--decode_guess":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.decodeGuess = value
 		case "--descriptor_set_in":
 			value, err := getOptionArg()
 			if err != nil {
 				return err
 			}
-			opts.inputDescriptors = append(opts.inputDescriptors, value)
+			opts.inputDescriptors = append(opts.inputDescriptors, splitPathList(value)...)
 		case "-o", "--descriptor_set_out":
 			value, err := getOptionArg()
 			if err != nil {
@@ -144,12 +211,175 @@ func parseFlags(source string, programName string, args []string, stdout io.Writ
 				return err
 			}
 			opts.includeSourceInfo = value
+		case "--dependency_out":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			if value == "" {
+				return fmt.Errorf("%s%s requires a non-empty value", loc(), parts[0])
+			}
+			if opts.dependencyOut != "" {
+				return fmt.Errorf("%s%s may only be passed once", loc(), parts[0])
+			}
+			opts.dependencyOut = value
+		case "--error_format":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			format, err := parseErrorFormat(value)
+			if err != nil {
+				return fmt.Errorf("%s%v", loc(), err)
+			}
+			opts.errorFormat = format
+		case "--fatal_warnings":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.fatalWarnings = value
+		case "--experimental_allow_proto3_optional":
+			// Proto3 optional fields are always allowed. But we accept this
+			// flag for compatibility with command-lines for older versions
+			// of protoc, which required it.
+			if _, err := getBoolArg(); err != nil {
+				return err
+			}
+		case "--experimental_editions":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.codeGen.experimentalEditions = value
+		case "--jobs":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			jobs, err := strconv.Atoi(value)
+			if err != nil || jobs < 1 {
+				return fmt.Errorf("%svalue for option %s must be a positive integer: %s", loc(), parts[0], value)
+			}
+			opts.codeGen.jobs = jobs
+		case "--check":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.codeGen.check = value
+		case "--diff":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.codeGen.diff = value
+		case "--remove_stale":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.codeGen.removeStale = value
+		case "--summary":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.codeGen.summary = value
+		case "--plugin_timeout":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("%svalue for option %s must be a positive duration: %s", loc(), parts[0], value)
+			}
+			opts.codeGen.pluginTimeout = timeout
+		case "--cache_dir":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			opts.codeGen.cacheDir = value
+		case "--cache_max_size":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			size, err := parseSize(value)
+			if err != nil {
+				return fmt.Errorf("%svalue for option %s: %v", loc(), parts[0], err)
+			}
+			opts.codeGen.cacheMaxSize = size
+		case "--no_cache":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.codeGen.noCache = value
+		case "--clear_cache":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.clearCache = value
+		case "--print_proto":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.printProto = value
+		case "--descriptor_set_to_proto":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			if value == "" {
+				return fmt.Errorf("%s%s requires a non-empty value", loc(), parts[0])
+			}
+			opts.protoOutDir = value
+		case "--watch":
+			value, err := getBoolArg()
+			if err != nil {
+				return err
+			}
+			opts.watch = value
 		case "--print_free_field_numbers":
 			value, err := getBoolArg()
 			if err != nil {
 				return err
 			}
 			opts.printFreeFieldNumbers = value
+		case "--free_field_numbers_format":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			format, err := parseFreeFieldsFormat(value)
+			if err != nil {
+				return fmt.Errorf("%s%s: %v", loc(), parts[0], err)
+			}
+			opts.freeFields.format = format
+		case "--free_field_numbers_message":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			if value == "" {
+				return fmt.Errorf("%s%s requires a non-empty value", loc(), parts[0])
+			}
+			opts.freeFields.messages = append(opts.freeFields.messages, value)
+		case "--next_free_field_numbers":
+			value, err := getOptionArg()
+			if err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 || n > maxTag {
+				return fmt.Errorf("%svalue for option %s must be a positive integer no greater than %d: %s", loc(), parts[0], maxTag, value)
+			}
+			opts.freeFields.next = n
 		case "--plugin":
 			value, err := getOptionArg()
 			if err != nil {
@@ -171,10 +401,10 @@ func parseFlags(source string, programName string, args []string, stdout io.Writ
 				return fmt.Errorf("plugin name %s is not valid: name should have 'protoc-gen-' prefix", pluginName)
 			}
 			pluginName = pluginName[len("protoc-gen-"):]
-			if opts.pluginDefs == nil {
-				opts.pluginDefs = make(map[string]string, 1)
+			if opts.codeGen.pluginDefs == nil {
+				opts.codeGen.pluginDefs = make(map[string]string, 1)
 			}
-			opts.pluginDefs[pluginName] = pluginLocation
+			opts.codeGen.pluginDefs[pluginName] = pluginLocation
 		default:
 			switch {
 			case strings.HasPrefix(a, "@"):
@@ -209,3 +439,17 @@ func parseFlags(source string, programName string, args []string, stdout io.Writ
 	}
 	return nil
 }
+
+// splitPathList splits the given value, which may be a list of paths separated
+// by os.PathListSeparator, into its constituent paths. Empty elements are
+// discarded.
+func splitPathList(value string) []string {
+	parts := filepath.SplitList(value)
+	paths := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
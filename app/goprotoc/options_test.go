@@ -0,0 +1,32 @@
+package goprotoc
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseFlags_PathLists(t *testing.T) {
+	sep := string(os.PathListSeparator)
+	args := []string{
+		"--descriptor_set_in=a.pb" + sep + "b.pb",
+		"--descriptor_set_in", "c.pb",
+		"-I", "foo" + sep + sep + "bar",
+		"--proto_path=baz",
+		"test.proto",
+	}
+	var opts protocOptions
+	if err := parseFlags("", "goprotoc", args, io.Discard, &opts, map[string]struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a.pb", "b.pb", "c.pb"}; !reflect.DeepEqual(opts.inputDescriptors, expected) {
+		t.Errorf("wrong descriptor sets: expected %v, got %v", expected, opts.inputDescriptors)
+	}
+	if expected := []string{"foo", "bar", "baz"}; !reflect.DeepEqual(opts.includePaths, expected) {
+		t.Errorf("wrong import paths: expected %v, got %v", expected, opts.includePaths)
+	}
+	if expected := []string{"test.proto"}; !reflect.DeepEqual(opts.protoFiles, expected) {
+		t.Errorf("wrong proto files: expected %v, got %v", expected, opts.protoFiles)
+	}
+}
@@ -0,0 +1,89 @@
+package goprotoc
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoprint"
+)
+
+// protoPrinter is used to write .proto source from descriptors. Elements are
+// printed in the order in which they were defined, so the result is as close
+// as possible to the original source.
+var protoPrinter = &protoprint.Printer{}
+
+// doPrintProto writes .proto source for the given files to w. If
+// includeImports is true, source is also written for all of their
+// dependencies. When there is more than one file, each is preceded by a
+// comment with its name.
+func doPrintProto(fds []*desc.FileDescriptor, includeImports bool, w io.Writer) error {
+	files := filesToPrint(fds, includeImports)
+	for i, fd := range files {
+		if len(files) > 1 {
+			sep := ""
+			if i > 0 {
+				sep = "\n"
+			}
+			if _, err := fmt.Fprintf(w, "%s// File: %s\n\n", sep, fd.GetName()); err != nil {
+				return err
+			}
+		}
+		if err := protoPrinter.PrintProtoFile(fd, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doDescriptorSetToProto writes .proto source for the given files into the
+// given directory, each at a path that matches its name. If includeImports is
+// true, source is also written for all of their dependencies.
+func doDescriptorSetToProto(fds []*desc.FileDescriptor, includeImports bool, dir string) error {
+	for _, fd := range filesToPrint(fds, includeImports) {
+		if !isSafeRelativePath(fd.GetName()) {
+			return fmt.Errorf("cannot write source for %q: name must be a relative path that does not start with '..'", fd.GetName())
+		}
+		src, err := protoPrinter.PrintProtoToString(fd)
+		if err != nil {
+			return fmt.Errorf("failed to print %s: %v", fd.GetName(), err)
+		}
+		fileName := filepath.Join(dir, filepath.FromSlash(fd.GetName()))
+		if err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm); err != nil {
+			return err
+		}
+		if _, err := writeFileResult(fileName, strings.NewReader(src)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filesToPrint returns the given files and, if includeImports is true, all of
+// their dependencies, without duplicates. Dependencies come before the files
+// that import them.
+func filesToPrint(fds []*desc.FileDescriptor, includeImports bool) []*desc.FileDescriptor {
+	if !includeImports {
+		return fds
+	}
+	var files []*desc.FileDescriptor
+	seen := map[string]struct{}{}
+	var add func(fd *desc.FileDescriptor)
+	add = func(fd *desc.FileDescriptor) {
+		if _, ok := seen[fd.GetName()]; ok {
+			return
+		}
+		seen[fd.GetName()] = struct{}{}
+		for _, dep := range fd.GetDependencies() {
+			add(dep)
+		}
+		files = append(files, fd)
+	}
+	for _, fd := range fds {
+		add(fd)
+	}
+	return files
+}
@@ -0,0 +1,175 @@
+package goprotoc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var printProtoTestFiles = map[string]string{
+	"opts.proto": `
+		syntax = "proto2";
+		package test.opts;
+		import "google/protobuf/descriptor.proto";
+		extend google.protobuf.MessageOptions {
+		  optional string label = 50000;
+		}
+		extend google.protobuf.FieldOptions {
+		  repeated int32 tags = 50001;
+		}
+		`,
+	"test.proto": `
+		// Leading comment for the syntax.
+		syntax = "proto2";
+
+		package test;
+
+		import "opts.proto";
+
+		option go_package = "example.com/test";
+
+		// Foo is a message.
+		message Foo {
+		  option (test.opts.label) = "foo";
+		  option deprecated = true;
+
+		  // The name.
+		  optional string name = 1 [default = "x", (test.opts.tags) = 1, (test.opts.tags) = 2];
+		  map<string, Foo> children = 2; // trailing comment
+		  optional group Bar = 3 {
+		    required int32 id = 1;
+		  }
+		  oneof choice {
+		    int64 num = 4 [json_name = "number"];
+		    bytes data = 5;
+		  }
+		  reserved 10 to 20, 100;
+		  reserved "old", "older";
+		  extensions 1000 to max;
+		}
+
+		enum Kind {
+		  option allow_alias = true;
+		  KIND_UNSPECIFIED = 0;
+		  KIND_A = 1;
+		  KIND_ALIAS = 1 [deprecated = true];
+		  reserved 5 to 9;
+		  reserved "KIND_OLD";
+		}
+
+		extend Foo {
+		  optional Kind kind = 1000;
+		}
+
+		service Svc {
+		  rpc Do(Foo) returns (stream Foo) {
+		    option idempotency_level = NO_SIDE_EFFECTS;
+		  }
+		}
+		`,
+}
+
+func TestDoPrintProto_RoundTrip(t *testing.T) {
+	p := protoparse.Parser{
+		Accessor:              protoparse.FileContentsFromMap(printProtoTestFiles),
+		IncludeSourceCodeInfo: true,
+	}
+	fds, err := p.ParseFiles("test.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := doDescriptorSetToProto(fds, true, dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"test.proto", "opts.proto", "google/protobuf/descriptor.proto"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("source for %s was not written: %v", name, err)
+		}
+	}
+	src, err := os.ReadFile(filepath.Join(dir, "test.proto"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []string{"// Leading comment for the syntax.", "// Foo is a message.", "// The name.", "// trailing comment"} {
+		if !bytes.Contains(src, []byte(comment)) {
+			t.Errorf("comment %q missing from output:\n%s", comment, src)
+		}
+	}
+
+	// parsing the printed source must produce the same descriptors
+	reparsed, err := (&protoparse.Parser{ImportPaths: []string{dir}}).ParseFiles("test.proto")
+	if err != nil {
+		t.Fatalf("failed to parse printed source: %v\n%s", err, src)
+	}
+	for i, fd := range []*descriptorpb.FileDescriptorProto{fds[0].AsFileDescriptorProto(), fds[0].GetDependencies()[0].AsFileDescriptorProto()} {
+		got := reparsed[0].AsFileDescriptorProto()
+		if i > 0 {
+			got = reparsed[0].GetDependencies()[0].AsFileDescriptorProto()
+		}
+		if !sameDescriptorProto(t, fd, got) {
+			t.Errorf("%s: printed source is not equivalent to original:\n%s", fd.GetName(), src)
+		}
+	}
+}
+
+// sameDescriptorProto reports whether the given files are the same, ignoring
+// source code info. Custom options are compared by their wire format, since
+// they may be represented as either extensions or unknown fields.
+func sameDescriptorProto(t *testing.T, a, b *descriptorpb.FileDescriptorProto) bool {
+	t.Helper()
+	normalize := func(fd *descriptorpb.FileDescriptorProto) *descriptorpb.FileDescriptorProto {
+		data, err := proto.Marshal(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result descriptorpb.FileDescriptorProto
+		if err := proto.Unmarshal(data, &result); err != nil {
+			t.Fatal(err)
+		}
+		result.SourceCodeInfo = nil
+		return &result
+	}
+	return proto.Equal(normalize(a), normalize(b))
+}
+
+func TestRun_PrintProtoFromDescriptorSet(t *testing.T) {
+	p := protoparse.Parser{
+		Accessor:              protoparse.FileContentsFromMap(printProtoTestFiles),
+		IncludeSourceCodeInfo: true,
+	}
+	fds, err := p.ParseFiles("test.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptorSet := filepath.Join(t.TempDir(), "set.bin")
+	if err := saveDescriptor(descriptorSet, fds, true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"goprotoc", "--descriptor_set_in=" + descriptorSet, "--print_proto", "test.proto", "opts.proto"}
+	if err := run(context.Background(), args, nil, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	out := stdout.String()
+	if !strings.HasPrefix(out, "// File: test.proto\n\n") || !strings.Contains(out, "\n// File: opts.proto\n\n") {
+		t.Errorf("output should contain a header for each file:\n%s", out)
+	}
+	if !strings.Contains(out, "// Foo is a message.") {
+		t.Errorf("output should contain comments from source info:\n%s", out)
+	}
+
+	args = []string{"goprotoc", "--descriptor_set_in=" + descriptorSet, "--print_proto", "--cpp_out=.", "test.proto"}
+	if err := run(context.Background(), args, nil, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "other output directives") {
+		t.Errorf("expected error about conflicting outputs, got %v", err)
+	}
+}
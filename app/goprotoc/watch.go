@@ -0,0 +1,299 @@
+package goprotoc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+var (
+	// watchPollInterval is how often the import paths are scanned for
+	// changes to .proto files.
+	watchPollInterval = 500 * time.Millisecond
+	// watchQuietPeriod is how long files must go without changing, after a
+	// change is seen, before code is generated again. This way, a burst of
+	// edits (such as from switching branches) results in a single build.
+	watchQuietPeriod = 200 * time.Millisecond
+)
+
+// fileStamp is used to detect when a file changes.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func (s fileStamp) same(other fileStamp) bool {
+	return s.size == other.size && s.modTime.Equal(other.modTime)
+}
+
+// watcher generates code again whenever the proto source files it is watching
+// change.
+type watcher struct {
+	opts           *protocOptions
+	errPrinter     *errorPrinter
+	stdout, stderr io.Writer
+	// roots are the absolute paths of the directories that are watched.
+	roots []string
+	// files are all of the files from the last successful parse, including
+	// dependencies, keyed by name.
+	files map[string]*desc.FileDescriptor
+	// dirty are the names of files that have changed since the last
+	// successful parse.
+	dirty map[string]struct{}
+	// scanErr is the message of the error from the last scan, if it failed.
+	scanErr string
+}
+
+// runWatch generates code as described by opts and then keeps generating it
+// again whenever .proto files in the import paths change, until the given
+// context is cancelled. Errors are printed to stderr instead of returned, so a
+// mistake in a file doesn't stop the watch.
+func runWatch(ctx context.Context, opts *protocOptions, errPrinter *errorPrinter, stdout io.Writer, stderr io.Writer) error {
+	roots := opts.includePaths
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	w := &watcher{
+		opts:       opts,
+		errPrinter: errPrinter,
+		stdout:     stdout,
+		stderr:     stderr,
+		dirty:      map[string]struct{}{},
+	}
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		w.roots = append(w.roots, abs)
+	}
+
+	stamps, err := scanProtoFiles(w.roots)
+	if err != nil {
+		return err
+	}
+	w.build(ctx)
+	_, _ = fmt.Fprintln(stderr, "Watching for changes...")
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+poll:
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		current, ok := w.scan()
+		if !ok || sameStamps(stamps, current) {
+			continue
+		}
+		// wait until the files stop changing
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(watchQuietPeriod):
+			}
+			next, ok := w.scan()
+			if !ok {
+				// go back to polling until the files can be scanned again
+				continue poll
+			}
+			if sameStamps(current, next) {
+				break
+			}
+			current = next
+		}
+		w.markChanged(stamps, current)
+		stamps = current
+		w.build(ctx)
+	}
+}
+
+// scan returns the stamps of the .proto files in the watched directories. If
+// they can't be scanned, it reports the error and returns false. An error is
+// only reported once, until a scan succeeds again, so that it doesn't fill the
+// output while, for example, a watched directory is missing.
+func (w *watcher) scan() (map[string]fileStamp, bool) {
+	stamps, err := scanProtoFiles(w.roots)
+	if err != nil {
+		if err.Error() != w.scanErr {
+			w.scanErr = err.Error()
+			w.report(err)
+		}
+		return nil, false
+	}
+	w.scanErr = ""
+	return stamps, true
+}
+
+// build parses the input files, reusing the results of the last parse for
+// files that have not changed, and generates code for them.
+func (w *watcher) build(ctx context.Context) {
+	start := time.Now()
+	opts := *w.opts
+	fileNames, err := resolveInputs(&opts)
+	if err != nil {
+		w.report(err)
+		return
+	}
+	reuse := w.reusableFiles()
+	fds, err := parseSources(fileNames, &opts, true, w.errPrinter, w.stderr, reuse)
+	if err != nil {
+		w.report(err)
+		return
+	}
+	w.files = map[string]*desc.FileDescriptor{}
+	for _, fd := range fds {
+		addFileAndDeps(w.files, fd)
+	}
+	w.dirty = map[string]struct{}{}
+	var parsed int
+	for name := range w.files {
+		if _, ok := reuse[name]; !ok {
+			parsed++
+		}
+	}
+
+	opts.protoFiles = fileNames
+	err = generateOutputs(ctx, &opts, fds, w.stdout, w.stderr)
+	if ctx.Err() != nil {
+		// interrupted, so nothing to report
+		return
+	}
+	if err != nil {
+		w.report(err)
+		return
+	}
+	_, _ = fmt.Fprintf(w.stderr, "Generated code for %d files (%d parsed) in %v.\n", len(fds), parsed, time.Since(start).Round(time.Millisecond))
+}
+
+// reusableFiles returns the files from the last successful parse that do not
+// need to be parsed again: those that have not changed and that do not
+// depend, directly or indirectly, on any that have.
+func (w *watcher) reusableFiles() map[string]*desc.FileDescriptor {
+	dependents := map[string][]string{}
+	for name, fd := range w.files {
+		for _, dep := range fd.GetDependencies() {
+			dependents[dep.GetName()] = append(dependents[dep.GetName()], name)
+		}
+	}
+	affected := map[string]struct{}{}
+	var queue []string
+	for name := range w.dirty {
+		queue = append(queue, name)
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, ok := affected[name]; ok {
+			continue
+		}
+		affected[name] = struct{}{}
+		queue = append(queue, dependents[name]...)
+	}
+	reuse := map[string]*desc.FileDescriptor{}
+	for name, fd := range w.files {
+		if _, ok := affected[name]; !ok {
+			reuse[name] = fd
+		}
+	}
+	return reuse
+}
+
+// markChanged records the names of the files that differ between the given
+// scans as dirty.
+func (w *watcher) markChanged(before, after map[string]fileStamp) {
+	mark := func(fileName string) {
+		for _, root := range w.roots {
+			if strings.HasPrefix(fileName, root+string(filepath.Separator)) {
+				w.dirty[filepath.ToSlash(fileName[len(root)+1:])] = struct{}{}
+			}
+		}
+	}
+	for fileName, stamp := range after {
+		if prev, ok := before[fileName]; !ok || !prev.same(stamp) {
+			mark(fileName)
+		}
+	}
+	for fileName := range before {
+		if _, ok := after[fileName]; !ok {
+			mark(fileName)
+		}
+	}
+}
+
+func (w *watcher) report(err error) {
+	if err == errFatalWarnings {
+		// the warnings have already been printed
+		return
+	}
+	_, _ = fmt.Fprintln(w.stderr, w.errPrinter.formatError(err))
+}
+
+func addFileAndDeps(files map[string]*desc.FileDescriptor, fd *desc.FileDescriptor) {
+	if _, ok := files[fd.GetName()]; ok {
+		return
+	}
+	files[fd.GetName()] = fd
+	for _, dep := range fd.GetDependencies() {
+		addFileAndDeps(files, dep)
+	}
+}
+
+// scanProtoFiles returns the stamps of all .proto files in the given
+// directories, keyed by absolute path. Hidden directories are skipped.
+func scanProtoFiles(roots []string) (map[string]fileStamp, error) {
+	stamps := map[string]fileStamp{}
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(fileName string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if fileName == root {
+					return err
+				}
+				// can't read this directory (it may have just been
+				// removed), so skip it
+				return nil
+			}
+			if d.IsDir() {
+				if fileName != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(fileName) != ".proto" {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				// removed since the directory was read
+				return nil
+			}
+			stamps[fileName] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stamps, nil
+}
+
+func sameStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for fileName, stamp := range a {
+		if other, ok := b[fileName]; !ok || !other.same(stamp) {
+			return false
+		}
+	}
+	return true
+}
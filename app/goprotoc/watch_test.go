@@ -0,0 +1,261 @@
+package goprotoc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhump/goprotoc/plugins"
+	"github.com/jhump/protoreflect/desc"
+)
+
+func TestParseSources_Reuse(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.proto": `syntax = "proto3"; import "b.proto"; message A { B b = 1; }`,
+		"b.proto": `syntax = "proto3"; message B {}`,
+		"c.proto": `syntax = "proto3"; message C {}`,
+	})
+	opts := &protocOptions{includePaths: []string{dir}}
+	errPrinter := &errorPrinter{importPaths: opts.includePaths}
+	var stderr bytes.Buffer
+	fds, err := parseSources([]string{"a.proto", "c.proto"}, opts, true, errPrinter, &stderr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change b.proto, but reuse the old b.proto and c.proto. The new
+	// contents of b.proto must not be seen.
+	writeTestFiles(t, dir, map[string]string{
+		"b.proto": `syntax = "proto3"; message B { string name = 1; }`,
+	})
+	reuse := map[string]*desc.FileDescriptor{
+		"b.proto": fds[0].GetDependencies()[0],
+		"c.proto": fds[1],
+	}
+	newFds, err := parseSources([]string{"a.proto", "c.proto"}, opts, true, errPrinter, &stderr, reuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newFds[0].UnwrapFile() == fds[0].UnwrapFile() {
+		t.Error("a.proto should have been parsed again")
+	}
+	if newFds[1].UnwrapFile() != fds[1].UnwrapFile() {
+		t.Error("c.proto should have been reused")
+	}
+	if fields := newFds[0].GetDependencies()[0].GetMessageTypes()[0].GetFields(); len(fields) != 0 {
+		t.Error("b.proto should have been reused")
+	}
+}
+
+func TestWatcher_ReusableFiles(t *testing.T) {
+	fds := parseTestFiles(t, map[string]string{
+		"a.proto": `syntax = "proto3"; import "b.proto"; message A { B b = 1; }`,
+		"b.proto": `syntax = "proto3"; import "c.proto"; message B { C c = 1; }`,
+		"c.proto": `syntax = "proto3"; message C {}`,
+		"d.proto": `syntax = "proto3"; import "c.proto"; message D {}`,
+		"e.proto": `syntax = "proto3"; message E {}`,
+	}, "a.proto", "d.proto", "e.proto")
+	w := &watcher{files: map[string]*desc.FileDescriptor{}, dirty: map[string]struct{}{"b.proto": {}}}
+	for _, fd := range fds {
+		addFileAndDeps(w.files, fd)
+	}
+	var reused []string
+	for name := range w.reusableFiles() {
+		reused = append(reused, name)
+	}
+	sort.Strings(reused)
+	if expected := "c.proto d.proto e.proto"; strings.Join(reused, " ") != expected {
+		t.Errorf("wrong files reused: expected %s, got %s", expected, strings.Join(reused, " "))
+	}
+}
+
+func TestRunWatch(t *testing.T) {
+	defer func(poll, quiet time.Duration) {
+		watchPollInterval, watchQuietPeriod = poll, quiet
+	}(watchPollInterval, watchQuietPeriod)
+	watchPollInterval, watchQuietPeriod = 10*time.Millisecond, 10*time.Millisecond
+
+	registerTestPlugin(t, "test_watch", func(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error {
+		var names []string
+		for _, fd := range req.Files {
+			for _, md := range fd.GetMessageTypes() {
+				names = append(names, md.GetName())
+			}
+		}
+		_, err := resp.OutputFile("messages.txt").Write([]byte(strings.Join(names, " ")))
+		return err
+	})
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"proto/a.proto": `syntax = "proto3"; import "b.proto"; message A { B b = 1; }`,
+		"proto/b.proto": `syntax = "proto3"; message B {}`,
+		"gen/.keep":     ``,
+	})
+	output := filepath.Join(dir, "gen", "messages.txt")
+	var stderr syncBuffer
+	waitFor := func(desc string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s; output:\n%s", desc, stderr.String())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForOutput := func(expected string) {
+		t.Helper()
+		waitFor("output "+expected, func() bool {
+			b, err := os.ReadFile(output)
+			return err == nil && string(b) == expected
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		args := []string{"goprotoc", "--watch", "-I", filepath.Join(dir, "proto"), "--test_watch_out=" + filepath.Join(dir, "gen"), filepath.Join(dir, "proto")}
+		done <- run(ctx, args, nil, &bytes.Buffer{}, &stderr)
+	}()
+
+	waitForOutput("A B")
+	// new files that match the inputs are picked up
+	writeTestFiles(t, dir, map[string]string{
+		"proto/c.proto": `syntax = "proto3"; message C {}`,
+	})
+	waitForOutput("A B C")
+	// errors are reported without exiting
+	writeTestFiles(t, dir, map[string]string{
+		"proto/b.proto": `syntax = "proto3"; message B {`,
+	})
+	waitFor("syntax error", func() bool {
+		return strings.Contains(stderr.String(), "b.proto:1:31: syntax error")
+	})
+	writeTestFiles(t, dir, map[string]string{
+		"proto/b.proto": `syntax = "proto3"; message B {} message B2 {}`,
+	})
+	waitForOutput("A B B2 C")
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("watch returned error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("watch did not stop after context was cancelled")
+	}
+	if !strings.Contains(stderr.String(), "Generated code for 3 files (2 parsed)") {
+		t.Errorf("expected only changed files to be parsed; output:\n%s", stderr.String())
+	}
+}
+
+func TestRunWatch_RootRemoved(t *testing.T) {
+	defer func(poll, quiet time.Duration) {
+		watchPollInterval, watchQuietPeriod = poll, quiet
+	}(watchPollInterval, watchQuietPeriod)
+	watchPollInterval, watchQuietPeriod = 10*time.Millisecond, 10*time.Millisecond
+
+	registerTestPlugin(t, "test_watch_removed", func(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error {
+		_, err := resp.OutputFile("done.txt").Write([]byte(req.Files[0].GetMessageTypes()[0].GetName()))
+		return err
+	})
+
+	dir := t.TempDir()
+	protoDir := filepath.Join(dir, "proto")
+	writeTestFiles(t, protoDir, map[string]string{"a.proto": `syntax = "proto3"; message A {}`})
+	output := filepath.Join(dir, "done.txt")
+	var stderr syncBuffer
+	waitFor := func(desc string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s; output:\n%s", desc, stderr.String())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForOutput := func(expected string) {
+		t.Helper()
+		waitFor("output "+expected, func() bool {
+			b, err := os.ReadFile(output)
+			return err == nil && string(b) == expected
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		args := []string{"goprotoc", "--watch", "-I", protoDir, "--test_watch_removed_out=" + dir, "a.proto"}
+		done <- run(ctx, args, nil, &bytes.Buffer{}, &stderr)
+	}()
+	waitForOutput("A")
+
+	if err := os.RemoveAll(protoDir); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("scan error", func() bool {
+		return strings.Contains(stderr.String(), protoDir)
+	})
+	// many polls later, the error has still only been reported once
+	time.Sleep(20 * watchPollInterval)
+	if n := strings.Count(stderr.String(), protoDir); n != 1 {
+		t.Errorf("expected scan error to be reported once, got %d times; output:\n%s", n, stderr.String())
+	}
+
+	// once the directory is back, the watch picks up where it left off
+	writeTestFiles(t, protoDir, map[string]string{"a.proto": `syntax = "proto3"; message B {}`})
+	waitForOutput("B")
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("watch returned error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("watch did not stop after context was cancelled")
+	}
+}
+
+func TestRunWatch_InvalidOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"test.proto": `syntax = "proto3"; message Foo {}`})
+	for _, flag := range []string{"--print_proto", "--descriptor_set_to_proto=" + dir, "--print_free_field_numbers"} {
+		var stdout, stderr bytes.Buffer
+		args := []string{"goprotoc", "--watch", flag, "-I", dir, "test.proto"}
+		err := run(context.Background(), args, nil, &stdout, &stderr)
+		if err == nil || err.Error() != "Can only use --watch when generating code." {
+			t.Errorf("%s: expected error about --watch, got %v", flag, err)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,313 @@
+package goprotoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+//lint:file-ignore ST1005 capitalized errors that are sentences are command return values printed to stderr
+
+// workspaceConfigName is the name of the config file that the generate
+// command looks for in the working directory and its parents.
+const workspaceConfigName = "goprotoc.yaml"
+
+// workspaceConfig is the contents of a goprotoc.yaml file. Relative paths in
+// the config are relative to the directory that contains the file.
+type workspaceConfig struct {
+	// ProtoPath lists the directories in which to search for imports. If
+	// empty, the directory that contains the config file is used.
+	ProtoPath []string `yaml:"proto_path,omitempty"`
+	// Inputs lists the files to compile, as patterns (see expandGlob) or
+	// directories, which include all .proto files under them.
+	Inputs []string `yaml:"inputs,omitempty"`
+	// Exclude lists patterns for files to leave out of the inputs. They are
+	// matched against file names relative to their import path, as for the
+	// --exclude flag.
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Plugins configures the outputs to generate, keyed by output name,
+	// which is the same as NAME in a --NAME_out flag.
+	Plugins map[string]*workspacePluginConfig `yaml:"plugins,omitempty"`
+	// Lint configures the lint command.
+	Lint *workspaceLintConfig `yaml:"lint,omitempty"`
+
+	// The remaining fields correspond to command-line flags of the same name.
+	Jobs                 int    `yaml:"jobs,omitempty"`
+	PluginTimeout        string `yaml:"plugin_timeout,omitempty"`
+	RemoveStale          bool   `yaml:"remove_stale,omitempty"`
+	CacheDir             string `yaml:"cache_dir,omitempty"`
+	CacheMaxSize         string `yaml:"cache_max_size,omitempty"`
+	ExperimentalEditions bool   `yaml:"experimental_editions,omitempty"`
+	FatalWarnings        bool   `yaml:"fatal_warnings,omitempty"`
+}
+
+type workspacePluginConfig struct {
+	// Out is the directory (or zip or jar file) where generated files are
+	// written.
+	Out string `yaml:"out"`
+	// Location is the path of the plugin executable. If empty, an executable
+	// named "protoc-gen-NAME" is used, as for a --NAME_out flag.
+	Location string `yaml:"location,omitempty"`
+	// Params are the parameters passed to the plugin.
+	Params []string `yaml:"params,omitempty"`
+	// Timeout, if not empty, overrides the top-level plugin_timeout.
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+type workspaceLintConfig struct {
+	// Enable lists rules to run in addition to those that run by default.
+	Enable []string `yaml:"enable,omitempty"`
+	// Disable lists rules not to run.
+	Disable []string `yaml:"disable,omitempty"`
+	// Ignore lists patterns for files that are not checked by any rule. They
+	// are matched against file names relative to their import path, as for
+	// the --exclude flag.
+	Ignore []string `yaml:"ignore,omitempty"`
+	// IgnoreOnly lists patterns, keyed by rule, for files that are not
+	// checked by that rule.
+	IgnoreOnly map[string][]string `yaml:"ignore_only,omitempty"`
+}
+
+// runGenerate implements the generate command, which generates code as
+// configured by a goprotoc.yaml file. Other command-line flags are applied on
+// top of the config. If any proto files are named on the command-line, they
+// are compiled instead of the inputs listed in the config.
+func runGenerate(ctx context.Context, programName string, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	configFiles, args, err := extractFlag(args, "--config")
+	if err != nil {
+		return err
+	}
+	var configFile string
+	if len(configFiles) > 0 {
+		configFile = configFiles[len(configFiles)-1]
+	} else if configFile, err = findWorkspaceConfig(); err != nil {
+		return err
+	}
+	conf, err := loadWorkspaceConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	var opts protocOptions
+	if err := conf.apply(filepath.Dir(configFile), &opts); err != nil {
+		return fmt.Errorf("%s: %v", configFile, err)
+	}
+	numConfigFiles := len(opts.protoFiles)
+	if err := parseFlags("", programName, args, stdout, &opts, map[string]struct{}{}); err != nil {
+		switch err {
+		case errVersion, errUsage:
+			return nil
+		default:
+			return err
+		}
+	}
+	if len(opts.protoFiles) > numConfigFiles {
+		// files given on the command-line replace those in the config
+		opts.protoFiles = opts.protoFiles[numConfigFiles:]
+	}
+	if len(opts.output) == 0 {
+		return fmt.Errorf("%s: no plugins configured", configFile)
+	}
+	return runWithOptions(ctx, &opts, stdin, stdout, stderr)
+}
+
+// extractFlag removes all occurrences of the given flag, which must take a
+// value, from the given arguments and returns their values along with the
+// remaining arguments. This is used for flags that are specific to a command
+// and so are not understood by parseFlags.
+func extractFlag(args []string, flag string) ([]string, []string, error) {
+	var values []string
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		switch {
+		case a == flag:
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("Missing value for flag: %s", a)
+			}
+			i++
+			values = append(values, args[i])
+		case strings.HasPrefix(a, flag+"="):
+			values = append(values, a[len(flag)+1:])
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return values, rest, nil
+}
+
+// findWorkspaceConfig searches the working directory and then each of its
+// parents for a goprotoc.yaml file and returns the path of the first one found.
+func findWorkspaceConfig() (string, error) {
+	configFile, err := searchWorkspaceConfig()
+	if err != nil {
+		return "", err
+	}
+	if configFile == "" {
+		wd, _ := os.Getwd()
+		return "", fmt.Errorf("Could not find %s in %s or any parent directory.", workspaceConfigName, wd)
+	}
+	return configFile, nil
+}
+
+// searchWorkspaceConfig is like findWorkspaceConfig, except that it returns
+// the empty string if there is no config file.
+func searchWorkspaceConfig() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for dir := wd; ; {
+		configFile := filepath.Join(dir, workspaceConfigName)
+		if _, err := os.Stat(configFile); err == nil {
+			return configFile, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+func loadWorkspaceConfig(configFile string) (*workspaceConfig, error) {
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config %s: %v", configFile, err)
+	}
+	var conf workspaceConfig
+	if err := yaml.UnmarshalStrict(b, &conf); err != nil {
+		return nil, fmt.Errorf("failed to load config %s: %v", configFile, err)
+	}
+	return &conf, nil
+}
+
+// apply sets the given options according to the config. Relative paths in the
+// config are resolved relative to dir.
+func (c *workspaceConfig) apply(dir string, opts *protocOptions) error {
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, filepath.FromSlash(p))
+	}
+
+	if len(c.ProtoPath) == 0 {
+		opts.includePaths = []string{dir}
+	}
+	for _, p := range c.ProtoPath {
+		opts.includePaths = append(opts.includePaths, resolve(p))
+	}
+
+	seen := map[string]struct{}{}
+	for _, pattern := range c.Inputs {
+		if !hasGlobMeta(pattern) {
+			if info, err := os.Stat(resolve(pattern)); err == nil && info.IsDir() {
+				pattern = path.Join(pattern, "**/*.proto")
+			}
+		}
+		files, err := expandGlob(dir, pattern)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if _, ok := seen[f]; !ok {
+				seen[f] = struct{}{}
+				opts.protoFiles = append(opts.protoFiles, f)
+			}
+		}
+	}
+	sort.Strings(opts.protoFiles)
+	for _, exclude := range c.Exclude {
+		if _, err := splitGlob(exclude); err != nil {
+			return fmt.Errorf("exclude: %v", err)
+		}
+	}
+	opts.excludes = append(opts.excludes, c.Exclude...)
+
+	names := make([]string, 0, len(c.Plugins))
+	for name := range c.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pl := c.Plugins[name]
+		if pl == nil || pl.Out == "" {
+			return fmt.Errorf("plugin %s: out must be specified", name)
+		}
+		if opts.output == nil {
+			opts.output = make(map[string]string, len(c.Plugins))
+		}
+		// Always include the separator, even when there are no params, so a
+		// colon in the output path is not mistaken for one.
+		opts.output[name] = strings.Join(pl.Params, ",") + ":" + resolve(pl.Out)
+		if pl.Location != "" {
+			location := pl.Location
+			if strings.ContainsRune(filepath.ToSlash(location), '/') {
+				// a path, not the name of an executable on the PATH
+				location = resolve(location)
+			}
+			if opts.codeGen.pluginDefs == nil {
+				opts.codeGen.pluginDefs = map[string]string{}
+			}
+			opts.codeGen.pluginDefs[name] = location
+		}
+		if pl.Timeout != "" {
+			timeout, err := parseConfigDuration(pl.Timeout)
+			if err != nil {
+				return fmt.Errorf("plugin %s: timeout: %v", name, err)
+			}
+			if opts.codeGen.pluginTimeouts == nil {
+				opts.codeGen.pluginTimeouts = map[string]time.Duration{}
+			}
+			opts.codeGen.pluginTimeouts[name] = timeout
+		}
+	}
+
+	if c.Jobs < 0 {
+		return errors.New("jobs must be a positive integer")
+	}
+	opts.codeGen.jobs = c.Jobs
+	if c.PluginTimeout != "" {
+		timeout, err := parseConfigDuration(c.PluginTimeout)
+		if err != nil {
+			return fmt.Errorf("plugin_timeout: %v", err)
+		}
+		opts.codeGen.pluginTimeout = timeout
+	}
+	opts.codeGen.removeStale = c.RemoveStale
+	opts.codeGen.cacheDir = resolve(c.CacheDir)
+	if c.CacheMaxSize != "" {
+		size, err := parseSize(c.CacheMaxSize)
+		if err != nil {
+			return fmt.Errorf("cache_max_size: %v", err)
+		}
+		opts.codeGen.cacheMaxSize = size
+	}
+	opts.codeGen.experimentalEditions = c.ExperimentalEditions
+	opts.fatalWarnings = c.FatalWarnings
+	return nil
+}
+
+func parseConfigDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("must be a positive duration: %s", s)
+	}
+	return d, nil
+}
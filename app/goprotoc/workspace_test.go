@@ -0,0 +1,113 @@
+package goprotoc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhump/goprotoc/plugins"
+)
+
+func TestRunGenerate(t *testing.T) {
+	registerTestPlugin(t, "test_workspace", func(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error {
+		var names []string
+		for _, fd := range req.Files {
+			names = append(names, fd.GetName())
+		}
+		_, err := resp.OutputFile("files.txt").Write([]byte(strings.Join(names, " ") + "\n" + strings.Join(req.Args, " ") + "\n"))
+		return err
+	})
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"goprotoc.yaml": `
+proto_path: [proto]
+inputs: ["proto/**/*.proto"]
+plugins:
+  test_workspace:
+    out: gen
+    params: [a=b, c]
+`,
+		"proto/foo/foo.proto":     `syntax = "proto3"; package foo; import "bar/bar.proto"; message Foo { bar.Bar bar = 1; }`,
+		"proto/bar/bar.proto":     `syntax = "proto3"; package bar; message Bar {}`,
+		"proto/bar/baz/baz.proto": `syntax = "proto3"; package baz; message Baz {}`,
+		"proto/bar/README.md":     `not a proto`,
+		"gen/.keep":               ``,
+	})
+
+	generate := func(args ...string) string {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		args = append([]string{"goprotoc", "generate", "--config", filepath.Join(dir, "goprotoc.yaml")}, args...)
+		if err := run(context.Background(), args, nil, &stdout, &stderr); err != nil {
+			t.Fatalf("generate failed: %v", err)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, "gen", "files.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	expected := "bar/bar.proto bar/baz/baz.proto foo/foo.proto\na=b c\n"
+	if actual := generate(); actual != expected {
+		t.Errorf("wrong output: expected %q, got %q", expected, actual)
+	}
+	// files on the command-line replace the configured inputs
+	expected = "bar/bar.proto\na=b c\n"
+	if actual := generate(filepath.Join(dir, "proto/bar/bar.proto")); actual != expected {
+		t.Errorf("wrong output: expected %q, got %q", expected, actual)
+	}
+
+	// the config file is found by searching parent directories
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "proto", "bar")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+	configFile, err := findWorkspaceConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "goprotoc.yaml"); !sameFile(configFile, expected) {
+		t.Errorf("wrong config file found: expected %s, got %s", expected, configFile)
+	}
+}
+
+func TestLoadWorkspaceConfig_UnknownKey(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"goprotoc.yaml": "inputs: [a.proto]\nplugin:\n  go: {out: gen}\n",
+	})
+	_, err := loadWorkspaceConfig(filepath.Join(dir, "goprotoc.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "plugin") {
+		t.Errorf("expected error about unknown key, got %v", err)
+	}
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		fileName := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fileName, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
@@ -80,6 +80,8 @@ import (
 	"strings"
 
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
 	"gopkg.in/yaml.v2"
 
 	"github.com/jhump/goprotoc/cmd/protoc-gen-gox/goxplugin"
@@ -128,11 +130,13 @@ func doCodeGen(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error
 
 	// Now we can run them all in parallel.
 	grp, ctx := errgroup.WithContext(context.Background())
+	var plResps []*plugins.CodeGenResponse
 	for plName, plConf := range asGoPlugin {
 		pl := reg[plName]
 		plReq := *req
 		plReq.Args = plConf.Params
 		plResp := plugins.NewCodeGenResponse(plName, resp)
+		plResps = append(plResps, plResp)
 		grp.Go(func() error {
 			return pl(&plReq, plResp)
 		})
@@ -141,13 +145,51 @@ func doCodeGen(req *plugins.CodeGenRequest, resp *plugins.CodeGenResponse) error
 		plReq := *req
 		plReq.Args = plConf.Params
 		plResp := plugins.NewCodeGenResponse(plName, resp)
+		plResps = append(plResps, plResp)
 		loc := plConf.Location
 		grp.Go(func() error {
 			return plugins.Exec(ctx, loc, &plReq, plResp)
 		})
 	}
 
-	return grp.Wait()
+	if err := grp.Wait(); err != nil {
+		return err
+	}
+
+	// We can only claim support for features that all of the plugins support.
+	for f := range pluginpb.CodeGeneratorResponse_Feature_name {
+		feature := pluginpb.CodeGeneratorResponse_Feature(f)
+		if feature == pluginpb.CodeGeneratorResponse_FEATURE_NONE {
+			continue
+		}
+		supported := true
+		for _, plResp := range plResps {
+			if !plResp.IsFeatureSupported(feature) {
+				supported = false
+				break
+			}
+		}
+		if !supported {
+			continue
+		}
+		if feature == pluginpb.CodeGeneratorResponse_FEATURE_SUPPORTS_EDITIONS {
+			// the supported range is the intersection of all plugins' ranges
+			minEdition, maxEdition := descriptorpb.Edition_EDITION_UNKNOWN, descriptorpb.Edition_EDITION_MAX
+			for _, plResp := range plResps {
+				plMin, plMax := plResp.SupportedEditions()
+				if plMin > minEdition {
+					minEdition = plMin
+				}
+				if plMax < maxEdition {
+					maxEdition = plMax
+				}
+			}
+			resp.SupportsEditions(minEdition, maxEdition)
+		} else {
+			resp.SupportsFeatures(feature)
+		}
+	}
+	return nil
 }
 
 func getConfig(args []string) (*effectiveConfig, error) {
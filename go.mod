@@ -1,20 +1,13 @@
 module github.com/jhump/goprotoc
 
-go 1.19
+go 1.21
 
 require (
+	github.com/bufbuild/protocompile v0.14.1
 	github.com/golang/protobuf v1.5.4
-	github.com/jhump/gopoet v0.0.0-20190322174617-17282ff210b3
-	github.com/jhump/protoreflect v1.11.0
-	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4
-	google.golang.org/protobuf v1.33.0
-	gopkg.in/yaml.v2 v2.2.8
-)
-
-require (
-	github.com/kr/pretty v0.1.0 // indirect
-	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
-	golang.org/x/text v0.3.8 // indirect
-	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
-	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+	github.com/jhump/gopoet v0.1.0
+	github.com/jhump/protoreflect v1.17.0
+	golang.org/x/sync v0.8.0
+	google.golang.org/protobuf v1.34.2
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,43 @@
+package plugins
+
+import (
+	"fmt"
+
+	"github.com/bufbuild/protocompile/protoutil"
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ResolveFeatures computes the fully resolved features for the given element.
+// For files that use editions, features may be set on the element itself or
+// inherited from an enclosing element (such as a message or the file). Any
+// features not set at all take their default value for the file's edition.
+// For files that use proto2 or proto3 syntax, the result is the default
+// features for that syntax.
+//
+// Only the standard features, defined in google.protobuf.FeatureSet, are
+// resolved. Custom features, which are extensions of FeatureSet, are not
+// present in the result.
+func ResolveFeatures(d desc.Descriptor) (*descriptorpb.FeatureSet, error) {
+	unwrappable, ok := d.(interface {
+		Unwrap() protoreflect.Descriptor
+	})
+	if !ok {
+		return nil, fmt.Errorf("cannot resolve features for %s: unsupported descriptor type %T", d.GetFullyQualifiedName(), d)
+	}
+	element := unwrappable.Unwrap()
+
+	var features descriptorpb.FeatureSet
+	msg := features.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		val, err := protoutil.ResolveFeature(element, field)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve feature %s for %s: %v", field.Name(), d.GetFullyQualifiedName(), err)
+		}
+		msg.Set(field, val)
+	}
+	return &features, nil
+}
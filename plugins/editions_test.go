@@ -0,0 +1,66 @@
+package plugins
+
+import (
+	"testing"
+
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestResolveFeatures(t *testing.T) {
+	p := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{
+			"test.proto": `
+				edition = "2023";
+				option features.field_presence = IMPLICIT;
+				message Foo {
+				  string name = 1;
+				  int32 id = 2 [features.field_presence = EXPLICIT];
+				  repeated int32 vals = 3;
+				}`,
+			"test3.proto": `
+				syntax = "proto3";
+				message Bar {
+				  repeated int32 vals = 1;
+				}`,
+		}),
+	}
+	fds, err := p.ParseFiles("test.proto", "test3.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := fds[0].FindMessage("Foo")
+	features, err := ResolveFeatures(md.FindFieldByName("name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features.GetFieldPresence() != descriptorpb.FeatureSet_IMPLICIT {
+		t.Errorf("name: expecting field presence inherited from file, got %v", features.GetFieldPresence())
+	}
+	features, err = ResolveFeatures(md.FindFieldByName("id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features.GetFieldPresence() != descriptorpb.FeatureSet_EXPLICIT {
+		t.Errorf("id: expecting field presence set on field, got %v", features.GetFieldPresence())
+	}
+	features, err = ResolveFeatures(md.FindFieldByName("vals"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features.GetRepeatedFieldEncoding() != descriptorpb.FeatureSet_PACKED {
+		t.Errorf("vals: expecting default repeated field encoding, got %v", features.GetRepeatedFieldEncoding())
+	}
+
+	features, err = ResolveFeatures(fds[1].FindMessage("Bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features.GetFieldPresence() != descriptorpb.FeatureSet_IMPLICIT {
+		t.Errorf("Bar: expecting proto3 default field presence, got %v", features.GetFieldPresence())
+	}
+	if features.GetEnumType() != descriptorpb.FeatureSet_OPEN {
+		t.Errorf("Bar: expecting proto3 default enum type, got %v", features.GetEnumType())
+	}
+}
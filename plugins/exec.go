@@ -3,12 +3,14 @@ package plugins
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/jhump/protoreflect/desc"
 	"google.golang.org/protobuf/proto"
@@ -16,17 +18,53 @@ import (
 	"google.golang.org/protobuf/types/pluginpb"
 )
 
+// DefaultStderrTailSize is the default for ExecOptions.StderrTailSize.
+const DefaultStderrTailSize = 4096
+
+// ExecOptions configure how a plugin program is run by ExecWithOptions.
+type ExecOptions struct {
+	// Stderr is where the plugin's standard error output is written. If nil,
+	// os.Stderr is used.
+	Stderr io.Writer
+	// RawStderr, if true, causes the plugin's standard error output to be
+	// written to Stderr as is. Otherwise, each line is prefixed with the name
+	// of the plugin, so that output from plugins that run concurrently can be
+	// told apart.
+	RawStderr bool
+	// StderrTailSize is the maximum number of bytes from the end of the
+	// plugin's standard error output to include in the returned error if the
+	// plugin fails. If zero, DefaultStderrTailSize is used. If negative, none
+	// of the output is included.
+	StderrTailSize int
+}
+
 // Exec executes the protoc plugin at the given path, sending it the given
-// request and adding its generated code output to the given response.
+// request and adding its generated code output to the given response. It
+// uses default options, which means the plugin's standard error output is
+// written to os.Stderr, with each line prefixed by the plugin's name.
+//
+// If the given context is cancelled or times out before the plugin completes,
+// the plugin is killed. On Unix systems, the plugin is run in its own process
+// group, and the entire group is killed, so any processes started by the
+// plugin are also stopped.
 func Exec(ctx context.Context, pluginPath string, req *CodeGenRequest, resp *CodeGenResponse) error {
+	return ExecWithOptions(ctx, pluginPath, req, resp, nil)
+}
+
+// ExecWithOptions is like Exec, except the given options control how the
+// plugin is run. If opts is nil, default options are used.
+func ExecWithOptions(ctx context.Context, pluginPath string, req *CodeGenRequest, resp *CodeGenResponse, opts *ExecOptions) error {
 	if len(req.Files) == 0 {
 		return fmt.Errorf("nothing to generate: no files given")
 	}
+	if opts == nil {
+		opts = &ExecOptions{}
+	}
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	reqpb := toPbRequest(req)
+	reqpb := req.AsCodeGeneratorRequest()
 	reqBytes, err := proto.Marshal(reqpb)
 	if err != nil {
 		return fmt.Errorf("failed to marshal code gen request to bytes: %v", err)
@@ -34,12 +72,45 @@ func Exec(ctx context.Context, pluginPath string, req *CodeGenRequest, resp *Cod
 
 	pluginName := pluginName(path.Base(pluginPath))
 
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	var prefixed *prefixWriter
+	if !opts.RawStderr {
+		prefixed = newPrefixWriter(stderr, "["+pluginName+"] ")
+		stderr = prefixed
+	}
+	var tail *tailBuffer
+	tailSize := opts.StderrTailSize
+	if tailSize == 0 {
+		tailSize = DefaultStderrTailSize
+	}
+	if tailSize > 0 {
+		tail = &tailBuffer{max: tailSize}
+		stderr = io.MultiWriter(stderr, tail)
+	}
+
 	cmd := exec.CommandContext(ctx, pluginPath)
-	cmd.Stderr = os.Stderr
+	configureCommand(cmd)
+	// If the plugin is killed but something else still holds its output
+	// open, don't wait forever.
+	cmd.WaitDelay = time.Second
+	cmd.Stderr = stderr
 	cmd.Stdin = bytes.NewReader(reqBytes)
 
 	respBytes, err := cmd.Output()
+	if prefixed != nil {
+		// write any final line that didn't end with a newline
+		_ = prefixed.Flush()
+	}
 	if err != nil {
+		var exitErr *exec.ExitError
+		if tail != nil && errors.As(err, &exitErr) {
+			if output := tail.String(); output != "" {
+				return fmt.Errorf("executing plugin %q failed: %v; stderr:\n%s", pluginName, err, output)
+			}
+		}
 		return fmt.Errorf("executing plugin %q failed: %v", pluginName, err)
 	}
 
@@ -47,18 +118,12 @@ func Exec(ctx context.Context, pluginPath string, req *CodeGenRequest, resp *Cod
 	if err := proto.Unmarshal(respBytes, &respb); err != nil {
 		return fmt.Errorf("failed to unmarshal code gen response to bytes: %v", err)
 	}
-
-	if respb.Error != nil {
-		return fmt.Errorf("%s", *respb.Error)
-	}
-	for _, res := range respb.File {
-		resp.output.addSnippet(pluginName, res.GetName(), res.GetInsertionPoint(), strings.NewReader(res.GetContent()))
-	}
-
-	return nil
+	return resp.addCodeGeneratorResponse(pluginName, &respb)
 }
 
-func toPbRequest(req *CodeGenRequest) *pluginpb.CodeGeneratorRequest {
+// AsCodeGeneratorRequest returns the request as the protobuf message that
+// protoc sends to plugins.
+func (req *CodeGenRequest) AsCodeGeneratorRequest() *pluginpb.CodeGeneratorRequest {
 	var reqpb pluginpb.CodeGeneratorRequest
 	vzero := ProtocVersion{}
 	if req.ProtocVersion != vzero {
@@ -178,8 +243,26 @@ func runPlugin(name string, plugin Plugin, reqpb *pluginpb.CodeGeneratorRequest)
 		return errResponse(name, err)
 	}
 
+	respb, err := resp.AsCodeGeneratorResponse()
+	if err != nil {
+		return errResponse(name, fmt.Errorf("failed to process code gen response: %v", err))
+	}
+	return respb
+}
+
+// AsCodeGeneratorResponse returns the outputs and supported features in the
+// response as the protobuf message that plugins send to protoc. This reads
+// the contents of all outputs, so they cannot be read again, such as via
+// ForEach, after this is called.
+func (resp *CodeGenResponse) AsCodeGeneratorResponse() (*pluginpb.CodeGeneratorResponse, error) {
 	var respb pluginpb.CodeGeneratorResponse
+	resp.mu.Lock()
 	respb.SupportedFeatures = proto.Uint64(resp.features)
+	if resp.features&uint64(pluginpb.CodeGeneratorResponse_FEATURE_SUPPORTS_EDITIONS) != 0 {
+		respb.MinimumEdition = proto.Int32(int32(resp.minEdition))
+		respb.MaximumEdition = proto.Int32(int32(resp.maxEdition))
+	}
+	resp.mu.Unlock()
 	resp.output.mu.Lock()
 	defer resp.output.mu.Unlock()
 
@@ -196,14 +279,39 @@ func runPlugin(name string, plugin Plugin, reqpb *pluginpb.CodeGeneratorRequest)
 		}
 		contents, err := io.ReadAll(&readers)
 		if err != nil {
-			return errResponse(name, fmt.Errorf("failed to process code gen response: %v", err))
+			return nil, err
 		}
 		contentStr := string(contents)
 		genFile.Content = &contentStr
 		respb.File = append(respb.File, &genFile)
 	}
 
-	return &respb
+	return &respb, nil
+}
+
+// AddCodeGeneratorResponse adds the outputs and supported features in the
+// given response, which is the protobuf message that plugins send to protoc,
+// to this response. If the given response indicates an error, the outputs are
+// not added and the error is returned.
+func (resp *CodeGenResponse) AddCodeGeneratorResponse(respb *pluginpb.CodeGeneratorResponse) error {
+	return resp.addCodeGeneratorResponse(resp.pluginName, respb)
+}
+
+func (resp *CodeGenResponse) addCodeGeneratorResponse(pluginName string, respb *pluginpb.CodeGeneratorResponse) error {
+	if respb.Error != nil {
+		return fmt.Errorf("%s", *respb.Error)
+	}
+	resp.mu.Lock()
+	resp.features |= respb.GetSupportedFeatures()
+	if resp.features&uint64(pluginpb.CodeGeneratorResponse_FEATURE_SUPPORTS_EDITIONS) != 0 {
+		resp.minEdition = descriptorpb.Edition(respb.GetMinimumEdition())
+		resp.maxEdition = descriptorpb.Edition(respb.GetMaximumEdition())
+	}
+	resp.mu.Unlock()
+	for _, res := range respb.File {
+		resp.output.addSnippet(pluginName, res.GetName(), res.GetInsertionPoint(), strings.NewReader(res.GetContent()))
+	}
+	return nil
 }
 
 func toDescriptors(fds []*descriptorpb.FileDescriptorProto, resolved map[string]*desc.FileDescriptor) error {
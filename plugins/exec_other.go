@@ -0,0 +1,9 @@
+//go:build !unix
+
+package plugins
+
+import "os/exec"
+
+// configureCommand is a no-op on platforms without process groups. When the
+// plugin is cancelled, only the plugin process itself is killed.
+func configureCommand(*exec.Cmd) {}
@@ -0,0 +1,86 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+func TestExecWithOptions_Stderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script as a plugin")
+	}
+	p := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{
+			"test.proto": `syntax = "proto3"; message Foo {}`,
+		}),
+	}
+	fds, err := p.ParseFiles("test.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	pluginPath := filepath.Join(dir, "protoc-gen-fail")
+	script := "#!/bin/sh\ncat > /dev/null\necho first >&2\nprintf 'second\\nno newline' >&2\nexit 3\n"
+	if err := os.WriteFile(pluginPath, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	req := CodeGenRequest{Files: fds}
+
+	var stderr bytes.Buffer
+	err = ExecWithOptions(context.Background(), pluginPath, &req, NewCodeGenResponse("fail", nil), &ExecOptions{Stderr: &stderr})
+	if err == nil {
+		t.Fatal("expected plugin to fail")
+	}
+	expected := "[fail] first\n[fail] second\n[fail] no newline\n"
+	if stderr.String() != expected {
+		t.Errorf("wrong stderr output: expected %q, got %q", expected, stderr.String())
+	}
+	if !strings.HasSuffix(err.Error(), "stderr:\nfirst\nsecond\nno newline") {
+		t.Errorf("error should include stderr output: %v", err)
+	}
+
+	stderr.Reset()
+	err = ExecWithOptions(context.Background(), pluginPath, &req, NewCodeGenResponse("fail", nil), &ExecOptions{Stderr: &stderr, RawStderr: true, StderrTailSize: 10})
+	if err == nil {
+		t.Fatal("expected plugin to fail")
+	}
+	expected = "first\nsecond\nno newline"
+	if stderr.String() != expected {
+		t.Errorf("wrong stderr output: expected %q, got %q", expected, stderr.String())
+	}
+	if !strings.HasSuffix(err.Error(), "stderr:\n...no newline") {
+		t.Errorf("error should include end of stderr output: %v", err)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	testCases := []struct {
+		writes   []string
+		expected string
+	}{
+		{writes: nil, expected: ""},
+		{writes: []string{"abc", "def\n"}, expected: "abcdef"},
+		{writes: []string{"abcdefgh"}, expected: "abcdefgh"},
+		{writes: []string{"abcdefghij"}, expected: "...cdefghij"},
+		{writes: []string{"abcde", "fghij"}, expected: "...cdefghij"},
+		{writes: []string{"ab", "cdefgh"}, expected: "abcdefgh"},
+		{writes: []string{"ab", "cdefghij"}, expected: "...cdefghij"},
+	}
+	for _, tc := range testCases {
+		tail := tailBuffer{max: 8}
+		for _, w := range tc.writes {
+			_, _ = tail.Write([]byte(w))
+		}
+		if actual := tail.String(); actual != tc.expected {
+			t.Errorf("writes %q: expected %q, got %q", tc.writes, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package plugins
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// configureCommand runs the plugin in its own process group, so that when the
+// plugin is cancelled, any processes it started are killed along with it.
+func configureCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		// a negative pid signals the whole process group
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
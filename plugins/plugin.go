@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/pluginpb"
 )
 
@@ -26,10 +27,19 @@ type CodeGenRequest struct {
 }
 
 // CodeGenResponse is how the plugin transmits generated code to protoc.
+//
+// A CodeGenResponse is safe for concurrent use, so plugins may generate
+// outputs from multiple goroutines. Responses created with NewCodeGenResponse
+// that share outputs with another response may also be used concurrently.
 type CodeGenResponse struct {
 	pluginName string
 	output     *outputMap
+
+	// mu protects the fields below
+	mu         sync.Mutex
 	features   uint64
+	minEdition descriptorpb.Edition
+	maxEdition descriptorpb.Edition
 }
 
 type outputMap struct {
@@ -100,11 +110,41 @@ func (resp *CodeGenResponse) ForEach(fn func(name, insertionPoint string, data i
 // SupportsFeatures allows the plugin to communicate which code generation features that
 // it supports.
 func (resp *CodeGenResponse) SupportsFeatures(feature ...pluginpb.CodeGeneratorResponse_Feature) {
+	resp.mu.Lock()
+	defer resp.mu.Unlock()
 	for _, f := range feature {
 		resp.features |= uint64(f)
 	}
 }
 
+// IsFeatureSupported returns true if the plugin has indicated, via
+// SupportsFeatures, that it supports the given code generation feature.
+func (resp *CodeGenResponse) IsFeatureSupported(feature pluginpb.CodeGeneratorResponse_Feature) bool {
+	resp.mu.Lock()
+	defer resp.mu.Unlock()
+	return resp.features&uint64(feature) != 0
+}
+
+// SupportsEditions allows the plugin to communicate that it supports files
+// that use editions, and the range of editions that it supports. This also
+// adds FEATURE_SUPPORTS_EDITIONS to the plugin's supported features.
+func (resp *CodeGenResponse) SupportsEditions(minEdition, maxEdition descriptorpb.Edition) {
+	resp.mu.Lock()
+	defer resp.mu.Unlock()
+	resp.features |= uint64(pluginpb.CodeGeneratorResponse_FEATURE_SUPPORTS_EDITIONS)
+	resp.minEdition = minEdition
+	resp.maxEdition = maxEdition
+}
+
+// SupportedEditions returns the range of editions that the plugin has indicated,
+// via SupportsEditions, that it supports. If the plugin does not support
+// editions, both returned values are zero (EDITION_UNKNOWN).
+func (resp *CodeGenResponse) SupportedEditions() (minEdition, maxEdition descriptorpb.Edition) {
+	resp.mu.Lock()
+	defer resp.mu.Unlock()
+	return resp.minEdition, resp.maxEdition
+}
+
 // ProtocVersion represents a version of the protoc tool.
 type ProtocVersion struct {
 	Major, Minor, Patch int
@@ -0,0 +1,94 @@
+package plugins
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// prefixedWritesMu serializes writes by all prefixWriters, so that lines from
+// plugins that run concurrently are not interleaved with one another.
+var prefixedWritesMu sync.Mutex
+
+// prefixWriter is an io.Writer that writes each line to an underlying writer,
+// prefixed with a fixed string. Output is buffered until a full line is
+// available, so each line is written to the underlying writer in one call.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: prefix}
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	i := bytes.LastIndexByte(p.buf, '\n')
+	if i < 0 {
+		return len(data), nil
+	}
+	lines := p.buf[:i+1]
+	if err := p.writeLines(lines); err != nil {
+		return 0, err
+	}
+	p.buf = append(p.buf[:0], p.buf[i+1:]...)
+	return len(data), nil
+}
+
+// Flush writes any final partial line, adding a trailing newline.
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	err := p.writeLines(append(p.buf, '\n'))
+	p.buf = p.buf[:0]
+	return err
+}
+
+func (p *prefixWriter) writeLines(lines []byte) error {
+	var out []byte
+	for len(lines) > 0 {
+		i := bytes.IndexByte(lines, '\n')
+		out = append(out, p.prefix...)
+		out = append(out, lines[:i+1]...)
+		lines = lines[i+1:]
+	}
+	prefixedWritesMu.Lock()
+	defer prefixedWritesMu.Unlock()
+	_, err := p.w.Write(out)
+	return err
+}
+
+// tailBuffer is an io.Writer that retains only the last max bytes written.
+type tailBuffer struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (t *tailBuffer) Write(data []byte) (int, error) {
+	n := len(data)
+	if len(data) > t.max {
+		t.truncated = true
+		t.buf = append(t.buf[:0], data[len(data)-t.max:]...)
+		return n, nil
+	}
+	if excess := len(t.buf) + len(data) - t.max; excess > 0 {
+		t.buf = append(t.buf[:0], t.buf[excess:]...)
+		t.truncated = true
+	}
+	t.buf = append(t.buf, data...)
+	return n, nil
+}
+
+// String returns the retained bytes, without any trailing newline. If earlier
+// output was discarded, the result starts with "...".
+func (t *tailBuffer) String() string {
+	s := string(bytes.TrimRight(t.buf, "\r\n"))
+	if t.truncated && s != "" {
+		s = "..." + s
+	}
+	return s
+}